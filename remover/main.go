@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -20,39 +22,214 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 )
 
+type Config struct {
+	Workspaces []Workspace `json:"workspaces"`
+}
+
+// Workspace is one Slack workspace to clean up. Token and channel values are expanded
+// with os.ExpandEnv, so a config file can refer to secrets such as "$SLACK_BOT_TOKEN_A".
+type Workspace struct {
+	Name      string `json:"name"`
+	BotToken  string `json:"bot_token"`
+	UserToken string `json:"user_token"`
+	ChannelId string `json:"channel_id"`
+	Days      int    `json:"days"`
+	KeepFiles bool   `json:"keep_files"`
+}
+
+type workspaceResult struct {
+	name           string
+	countByChannel map[string]int
+	channelById    map[string]slack.Channel
+	fileCount      int
+	duration       time.Duration
+	stats          *runStats
+	err            error
+}
+
+func (result workspaceResult) messageCount() int {
+	count := 0
+	for _, c := range result.countByChannel {
+		count += c
+	}
+	return count
+}
+
 type SlackClient struct {
 	*slack.Client
+	stats *runStats
+}
+
+// A nil *runStats is valid and records nothing.
+type runStats struct {
+	failedByErrorByChannel map[string]map[string]int
+	protectedByReason      map[string]int
+	callsByMethod          map[string]int
+	retriesByMethod        map[string]int
+	freedBytes             int64
+	processedChannels      int
+	skippedChannels        int
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		failedByErrorByChannel: map[string]map[string]int{},
+		protectedByReason:      map[string]int{},
+		callsByMethod:          map[string]int{},
+		retriesByMethod:        map[string]int{},
+	}
+}
+
+func (s *runStats) addFailure(channelID string, err error) {
+	if s == nil {
+		return
+	}
+	if _, ok := s.failedByErrorByChannel[channelID]; !ok {
+		s.failedByErrorByChannel[channelID] = map[string]int{}
+	}
+	s.failedByErrorByChannel[channelID][errorCode(err)]++
+}
+
+func (s *runStats) addProtected(reason string) {
+	if s == nil {
+		return
+	}
+	s.protectedByReason[reason]++
+}
+
+func (s *runStats) addCall(method string) {
+	if s == nil {
+		return
+	}
+	s.callsByMethod[method]++
+}
+
+func (s *runStats) addRetry(method string) {
+	if s == nil {
+		return
+	}
+	s.retriesByMethod[method]++
+}
+
+func (s *runStats) addFreedBytes(size int) {
+	if s == nil {
+		return
+	}
+	s.freedBytes += int64(size)
+}
+
+func (s *runStats) addChannel(processed bool) {
+	if s == nil {
+		return
+	}
+	if processed {
+		s.processedChannels++
+	} else {
+		s.skippedChannels++
+	}
+}
+
+func errorCode(err error) string {
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) {
+		return slackErr.Err
+	}
+	var rateLimitedErr *slack.RateLimitedError
+	if errors.As(err, &rateLimitedErr) {
+		return "ratelimited"
+	}
+	return "unknown"
+}
+
+const MAX_RETRIES = 3
+
+// call retries fn while Slack answers with a rate limit.
+func (client *SlackClient) call(method string, fn func() error) error {
+	for retry := 0; ; retry++ {
+		client.stats.addCall(method)
+		err := fn()
+		var rateLimitedErr *slack.RateLimitedError
+		if !errors.As(err, &rateLimitedErr) || retry >= MAX_RETRIES {
+			return err
+		}
+		client.stats.addRetry(method)
+		time.Sleep(rateLimitedErr.RetryAfter)
+	}
 }
 
 func (client *SlackClient) getChannels() ([]slack.Channel, error) {
-	channels, _, err := client.GetConversationsForUser(&slack.GetConversationsForUserParameters{})
+	var channels []slack.Channel
+	err := client.call("users.conversations", func() error {
+		var err error
+		channels, _, err = client.GetConversationsForUser(&slack.GetConversationsForUserParameters{})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("can not get channels: %w", err)
 	}
 	return channels, nil
 }
 
-func (client *SlackClient) postStartMessage() string {
-	_, ts, err := client.PostMessage(os.Getenv("SLACK_CHANNEL_ID"), slack.MsgOptionText("タスク実行を開始します", true))
+func (client *SlackClient) postStartMessage(channelID string) string {
+	var ts string
+	err := client.call("chat.postMessage", func() error {
+		var err error
+		_, ts, err = client.PostMessage(channelID, slack.MsgOptionText("タスク実行を開始します", true))
+		return err
+	})
 	if err != nil {
 		log.Println("Can not post start message:", err)
 	}
 	return ts
 }
 
-func (client *SlackClient) postEndMessage(duration time.Duration, ts string, messageCount, fileCount int) {
+func (client *SlackClient) postEndMessage(channelID string, duration time.Duration, ts string, messageCount, fileCount int) {
 	avg := float64(messageCount) / duration.Seconds()
 	message := "タスク実行を終了します\n" + duration.String() + "\n" + "message count: " + strconv.FormatInt(int64(messageCount), 10) + "\n" + "avg: " + strconv.FormatFloat(avg, 'f', -1, 64) + "/s" + "\n" + "file count: " + strconv.FormatInt(int64(fileCount), 10)
-	_, _, err := client.PostMessage(os.Getenv("SLACK_CHANNEL_ID"), slack.MsgOptionText(message, true), slack.MsgOptionTS(ts), slack.MsgOptionBroadcast())
+	err := client.call("chat.postMessage", func() error {
+		_, _, err := client.PostMessage(channelID, slack.MsgOptionText(message, true), slack.MsgOptionTS(ts), slack.MsgOptionBroadcast())
+		return err
+	})
 	if err != nil {
 		log.Println("End message can not post:", err)
 	}
 }
 
+func createTotalMessage(results []workspaceResult) string {
+	messageCount, fileCount, failed := 0, 0, 0
+	lines := []string{}
+	for _, result := range results {
+		count := result.messageCount()
+		messageCount += count
+		fileCount += result.fileCount
+		line := result.name + ": message count: " + strconv.Itoa(count) + ", file count: " + strconv.Itoa(result.fileCount)
+		if result.err != nil {
+			failed++
+			line += ", failed: " + result.err.Error()
+		}
+		lines = append(lines, line)
+	}
+	return "全ワークスペースのタスク実行を終了します\n" + "workspaces: " + strconv.Itoa(len(results)) + " (failed: " + strconv.Itoa(failed) + ")\n" + "message count: " + strconv.Itoa(messageCount) + "\n" + "file count: " + strconv.Itoa(fileCount) + "\n" + strings.Join(lines, "\n")
+}
+
+func (client *SlackClient) postTotalMessage(channelID string, results []workspaceResult) {
+	err := client.call("chat.postMessage", func() error {
+		_, _, err := client.PostMessage(channelID, slack.MsgOptionText(createTotalMessage(results), true))
+		return err
+	})
+	if err != nil {
+		log.Println("Total message can not post:", err)
+	}
+}
+
 func (client *SlackClient) deleteMessage(id, ts string) {
-	_, _, err := client.DeleteMessage(id, ts)
+	err := client.call("chat.delete", func() error {
+		_, _, err := client.DeleteMessage(id, ts)
+		return err
+	})
 	if err != nil {
 		log.Println("Can not delete message:", id, ":", ts, ":", err)
+		client.stats.addFailure(id, err)
 		if err.Error() != "message_not_found" {
 			recover()
 		}
@@ -75,20 +252,33 @@ func (client *SlackClient) loopInAllChannels(channels []slack.Channel, now time.
 		id := channel.ID
 		latest := strconv.FormatInt(now.AddDate(0, 0, -days).Unix(), 10)
 		params := slack.GetConversationHistoryParameters{ChannelID: id, Limit: 1000, Latest: latest}
-		res, err := client.GetConversationHistory(&params)
+		var res *slack.GetConversationHistoryResponse
+		err := client.call("conversations.history", func() error {
+			var err error
+			res, err = client.GetConversationHistory(&params)
+			return err
+		})
 		if err != nil {
 			log.Println("Can not get history:", err)
+			client.stats.addChannel(false)
 			continue
 		}
+		client.stats.addChannel(true)
 		count := 0
 		for _, message := range res.Messages {
 			if len(message.Reactions) > 0 {
+				client.stats.addProtected("reaction")
 				continue
 			}
 			count++
 			if message.ReplyCount != 0 {
 				repliesParams := slack.GetConversationRepliesParameters{ChannelID: id, Timestamp: message.Msg.Timestamp}
-				replies, _, _, err := client.GetConversationReplies(&repliesParams)
+				var replies []slack.Message
+				err := client.call("conversations.replies", func() error {
+					var err error
+					replies, _, _, err = client.GetConversationReplies(&repliesParams)
+					return err
+				})
 				if err != nil {
 					log.Println("Can not get replies:", err)
 				} else {
@@ -108,7 +298,12 @@ func (client *SlackClient) loopInAllChannels(channels []slack.Channel, now time.
 func (client *SlackClient) deleteFiles(now time.Time, days int) int {
 	latest := now.AddDate(0, 0, -days).Unix()
 	params := slack.GetFilesParameters{TimestampTo: slack.JSONTime(latest)}
-	res, _, err := client.GetFiles(params)
+	var res []slack.File
+	err := client.call("files.list", func() error {
+		var err error
+		res, _, err = client.GetFiles(params)
+		return err
+	})
 	count := 0
 	if err != nil {
 		log.Println("Can not get file:", err)
@@ -116,44 +311,131 @@ func (client *SlackClient) deleteFiles(now time.Time, days int) int {
 	}
 	for _, file := range res {
 		id := file.ID
-		err := client.DeleteFile(id)
+		err := client.call("files.delete", func() error {
+			return client.DeleteFile(id)
+		})
 		if err != nil {
 			log.Println("Can not delete file:", err)
+			// a file of a DM or not shared has no channel
+			channelID := "none"
+			if len(file.Channels) > 0 {
+				channelID = file.Channels[0]
+			}
+			client.stats.addFailure(channelID, err)
 			continue
 		}
+		client.stats.addFreedBytes(file.Size)
 		count++
 	}
 	return count
 }
 
-func main() {
-	botClient := &SlackClient{slack.New(os.Getenv("SLACK_BOT_TOKEN"))}
-	userClient := &SlackClient{slack.New(os.Getenv("SLACK_USER_TOKEN"))}
+func loadWorkspaces(configPath string) ([]Workspace, error) {
+	if configPath == "" {
+		return []Workspace{{
+			Name:      "default",
+			BotToken:  os.Getenv("SLACK_BOT_TOKEN"),
+			UserToken: os.Getenv("SLACK_USER_TOKEN"),
+			ChannelId: os.Getenv("SLACK_CHANNEL_ID"),
+			Days:      makeDays(os.Getenv("DAYS")),
+		}}, nil
+	}
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("can not open config: %w", err)
+	}
+	defer file.Close()
+
+	var config Config
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return nil, fmt.Errorf("can not decode config: %w", err)
+	}
+	if len(config.Workspaces) == 0 {
+		return nil, fmt.Errorf("no workspaces in config: %s", configPath)
+	}
+	defaultDays := 0
+	for i := range config.Workspaces {
+		workspace := &config.Workspaces[i]
+		if workspace.Name == "" {
+			workspace.Name = strconv.Itoa(i)
+		}
+		workspace.BotToken = os.ExpandEnv(workspace.BotToken)
+		workspace.UserToken = os.ExpandEnv(workspace.UserToken)
+		workspace.ChannelId = os.ExpandEnv(workspace.ChannelId)
+		if workspace.Days <= 0 {
+			if defaultDays == 0 {
+				defaultDays = makeDays(os.Getenv("DAYS"))
+			}
+			workspace.Days = defaultDays
+		}
+	}
+	return config.Workspaces, nil
+}
+
+// A failure, even a panic, is returned in the result so that other workspaces still run.
+func runWorkspace(workspace Workspace, options ...slack.Option) (result workspaceResult) {
+	stats := newRunStats()
+	result = workspaceResult{name: workspace.Name, countByChannel: map[string]int{}, channelById: map[string]slack.Channel{}, stats: stats}
 	start := time.Now()
-	ts := botClient.postStartMessage()
+	defer func() {
+		if r := recover(); r != nil {
+			result.err = fmt.Errorf("panic: %v", r)
+		}
+		result.duration = time.Since(start)
+	}()
+
+	botClient := &SlackClient{Client: slack.New(workspace.BotToken, options...), stats: stats}
+	userClient := &SlackClient{Client: slack.New(workspace.UserToken, options...), stats: stats}
+	ts := botClient.postStartMessage(workspace.ChannelId)
 	channels, err := userClient.getChannels()
 	if err != nil {
-		log.Println("Can not get channels", err)
+		result.err = err
+		return result
+	}
+	result.countByChannel = userClient.loopInAllChannels(channels, start, workspace.Days)
+	for _, ch := range channels {
+		result.channelById[ch.ID] = ch
+	}
+	if !workspace.KeepFiles {
+		result.fileCount = botClient.deleteFiles(start, workspace.Days)
+	}
+	botClient.postEndMessage(workspace.ChannelId, time.Since(start), ts, result.messageCount(), result.fileCount)
+	return result
+}
+
+// Without REMOVER_CONFIG, the only workspace is the one of SLACK_BOT_TOKEN, SLACK_USER_TOKEN and SLACK_CHANNEL_ID.
+func main() {
+	workspaces, err := loadWorkspaces(os.Getenv("REMOVER_CONFIG"))
+	if err != nil {
+		log.Println("Can not load workspaces:", err)
 		return
 	}
-	daysStr := os.Getenv("DAYS")
-	days := makeDays(daysStr)
-	countByChannel := userClient.loopInAllChannels(channels, start, days)
-	messageCount := 0
-	for _, c := range countByChannel {
-		messageCount += c
+	results := []workspaceResult{}
+	for _, workspace := range workspaces {
+		result := runWorkspace(workspace)
+		if result.err != nil {
+			log.Println("Can not clean up workspace:", workspace.Name, result.err)
+		}
+		log.Println("workspace:", workspace.Name, "messages:", result.messageCount(), "files:", result.fileCount, "duration:", result.duration)
+		results = append(results, result)
 	}
-	channelById := map[string]slack.Channel{}
-	for _, ch := range channels {
-		channelById[ch.ID] = ch
+	if len(workspaces) > 1 {
+		// the totals go to the report channel of the first workspace
+		botClient := &SlackClient{Client: slack.New(workspaces[0].BotToken)}
+		botClient.postTotalMessage(workspaces[0].ChannelId, results)
 	}
-	fileCount := botClient.deleteFiles(start, days)
-	duration := time.Since(start)
-	botClient.postEndMessage(duration, ts, messageCount, fileCount)
-	sendMetrics(countByChannel, channelById, fileCount, duration)
+	sendMetrics(results)
 }
 
-func sendMetrics(countByChannel map[string]int, channelById map[string]slack.Channel, fileCount int, duration time.Duration) {
+func sanitizeChannel(channelID string, channelById map[string]slack.Channel) string {
+	name := channelID
+	if channel, ok := channelById[channelID]; ok {
+		name = channel.Name
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(name, ".", "_"), "-", "_")
+}
+
+func sendMetrics(results []workspaceResult) {
 	otelExporterEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
 	if otelExporterEndpoint == "" {
 		// OTEL_EXPORTER_OTLP_METRICS_ENDPOINT is optional, so no need to log
@@ -235,23 +517,123 @@ func sendMetrics(countByChannel map[string]int, channelById map[string]slack.Cha
 		log.Println("failed to create duration histogram:", err)
 	}
 
-	if deletedMessagesCounter != nil {
-		for channelID, count := range countByChannel {
-			channel, ok := channelById[channelID]
-			if !ok {
-				continue
-			}
-			sanitizedChannel := strings.ReplaceAll(strings.ReplaceAll(channel.Name, ".", "_"), "-", "_")
-			opts := metric.WithAttributes(
-				attribute.String("channel", sanitizedChannel),
-			)
-			deletedMessagesCounter.Add(ctx, int64(count), opts)
-		}
+	failedDeletionsCounter, err := meter.Int64Counter("slack_failed_deletions",
+		metric.WithDescription("Number of messages and files that could not be deleted"),
+	)
+	if err != nil {
+		log.Println("failed to create failed deletions counter:", err)
+	}
+
+	protectedMessagesCounter, err := meter.Int64Counter("slack_protected_messages",
+		metric.WithDescription("Number of messages kept on purpose"),
+	)
+	if err != nil {
+		log.Println("failed to create protected messages counter:", err)
+	}
+
+	apiCallsCounter, err := meter.Int64Counter("slack_api_calls",
+		metric.WithDescription("Number of Slack API calls including retries"),
+	)
+	if err != nil {
+		log.Println("failed to create api calls counter:", err)
+	}
+
+	apiRetriesCounter, err := meter.Int64Counter("slack_api_retries",
+		metric.WithDescription("Number of Slack API calls retried after a rate limit"),
+	)
+	if err != nil {
+		log.Println("failed to create api retries counter:", err)
+	}
+
+	freedBytesCounter, err := meter.Int64Counter("slack_freed_bytes",
+		metric.WithDescription("Size of deleted files"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		log.Println("failed to create freed bytes counter:", err)
+	}
+
+	channelsCounter, err := meter.Int64Counter("slack_channels",
+		metric.WithDescription("Number of channels processed or skipped"),
+	)
+	if err != nil {
+		log.Println("failed to create channels counter:", err)
 	}
-	if deletedFilesCounter != nil {
-		deletedFilesCounter.Add(ctx, int64(fileCount))
+
+	workspacesCounter, err := meter.Int64Counter("slack_workspaces",
+		metric.WithDescription("Number of workspaces cleaned up or failed"),
+	)
+	if err != nil {
+		log.Println("failed to create workspaces counter:", err)
 	}
-	if removerDuration != nil {
-		removerDuration.Record(ctx, duration.Seconds())
+
+	for _, result := range results {
+		workspaceAttribute := attribute.String("workspace", result.name)
+		if workspacesCounter != nil {
+			status := "succeeded"
+			if result.err != nil {
+				status = "failed"
+			}
+			workspacesCounter.Add(ctx, 1, metric.WithAttributes(workspaceAttribute, attribute.String("status", status)))
+		}
+		if deletedMessagesCounter != nil {
+			for channelID, count := range result.countByChannel {
+				channel, ok := result.channelById[channelID]
+				if !ok {
+					continue
+				}
+				sanitizedChannel := strings.ReplaceAll(strings.ReplaceAll(channel.Name, ".", "_"), "-", "_")
+				opts := metric.WithAttributes(
+					workspaceAttribute,
+					attribute.String("channel", sanitizedChannel),
+				)
+				deletedMessagesCounter.Add(ctx, int64(count), opts)
+			}
+		}
+		if deletedFilesCounter != nil {
+			deletedFilesCounter.Add(ctx, int64(result.fileCount), metric.WithAttributes(workspaceAttribute))
+		}
+		if removerDuration != nil {
+			removerDuration.Record(ctx, result.duration.Seconds(), metric.WithAttributes(workspaceAttribute))
+		}
+		stats := result.stats
+		if stats == nil {
+			continue
+		}
+		if failedDeletionsCounter != nil {
+			for channelID, countByError := range stats.failedByErrorByChannel {
+				sanitizedChannel := sanitizeChannel(channelID, result.channelById)
+				for code, count := range countByError {
+					opts := metric.WithAttributes(
+						workspaceAttribute,
+						attribute.String("channel", sanitizedChannel),
+						attribute.String("error", code),
+					)
+					failedDeletionsCounter.Add(ctx, int64(count), opts)
+				}
+			}
+		}
+		if protectedMessagesCounter != nil {
+			for reason, count := range stats.protectedByReason {
+				protectedMessagesCounter.Add(ctx, int64(count), metric.WithAttributes(workspaceAttribute, attribute.String("reason", reason)))
+			}
+		}
+		if apiCallsCounter != nil {
+			for method, count := range stats.callsByMethod {
+				apiCallsCounter.Add(ctx, int64(count), metric.WithAttributes(workspaceAttribute, attribute.String("method", method)))
+			}
+		}
+		if apiRetriesCounter != nil {
+			for method, count := range stats.retriesByMethod {
+				apiRetriesCounter.Add(ctx, int64(count), metric.WithAttributes(workspaceAttribute, attribute.String("method", method)))
+			}
+		}
+		if freedBytesCounter != nil {
+			freedBytesCounter.Add(ctx, stats.freedBytes, metric.WithAttributes(workspaceAttribute))
+		}
+		if channelsCounter != nil {
+			channelsCounter.Add(ctx, int64(stats.processedChannels), metric.WithAttributes(workspaceAttribute, attribute.String("status", "processed")))
+			channelsCounter.Add(ctx, int64(stats.skippedChannels), metric.WithAttributes(workspaceAttribute, attribute.String("status", "skipped")))
+		}
 	}
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"embed"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -57,7 +58,7 @@ func TestGetChannels(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Helper()
 
-			gotChannels, err := (&SlackClient{client}).getChannels()
+			gotChannels, err := (&SlackClient{Client: client}).getChannels()
 
 			if len(gotChannels) != len(tt.want.channels) {
 				t.Errorf("getChannels() = %v, want %v", gotChannels, tt.want.channels)
@@ -115,7 +116,7 @@ func TestPostStartMessage(t *testing.T) {
 				buf.Reset()
 			}()
 
-			got := (&SlackClient{client}).postStartMessage()
+			got := (&SlackClient{Client: client}).postStartMessage("ABCDEF123")
 
 			if got != tt.want.ts {
 				t.Errorf("postStartMessage() = %v, want %v", got, tt.want.ts)
@@ -176,7 +177,7 @@ func TestPostEndMessage(t *testing.T) {
 				buf.Reset()
 			}()
 
-			(&SlackClient{client}).postEndMessage(1*time.Second, tt.args.ts, tt.args.messageCount, tt.args.fileCount)
+			(&SlackClient{Client: client}).postEndMessage("ABCDEF123", 1*time.Second, tt.args.ts, tt.args.messageCount, tt.args.fileCount)
 
 			gotPrint := strings.TrimRight(buf.String(), "\n")
 			if gotPrint != tt.want {
@@ -186,35 +187,91 @@ func TestPostEndMessage(t *testing.T) {
 	}
 }
 
+func TestPostTotalMessage(t *testing.T) {
+	results := []workspaceResult{
+		{name: "a", countByChannel: map[string]int{"C1": 2, "C2": 1}, fileCount: 1},
+		{name: "b", countByChannel: map[string]int{}, err: errors.New("can not get channels: invalid_auth")},
+	}
+	tests := []struct {
+		name   string
+		apiRes string
+		want   string
+	}{
+		{name: "PostTotalMessageOk", apiRes: "testdata/chatPostMessage/ok.json", want: ""},
+		{name: "PostTotalMessageError", apiRes: "testdata/chatPostMessage/error.json", want: "Total message can not post: too_many_attachments"},
+	}
+	for _, tt := range tests {
+		texts := []string{}
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+				texts = append(texts, r.FormValue("text"))
+				res, _ := testdata.ReadFile(tt.apiRes)
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		t.Run(tt.name, func(t *testing.T) {
+			t.Helper()
+
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defaultFlags := log.Flags()
+			log.SetFlags(0)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(defaultFlags)
+				buf.Reset()
+			}()
+
+			(&SlackClient{Client: client}).postTotalMessage("ABCDEF123", results)
+
+			wantText := "全ワークスペースのタスク実行を終了します\nworkspaces: 2 (failed: 1)\nmessage count: 3\nfile count: 1\na: message count: 3, file count: 1\nb: message count: 0, file count: 0, failed: can not get channels: invalid_auth"
+			if len(texts) != 1 || texts[0] != wantText {
+				t.Errorf("postTotalMessage() text = %v, want %v", texts, wantText)
+			}
+			gotPrint := strings.TrimRight(buf.String(), "\n")
+			if gotPrint != tt.want {
+				t.Errorf("postTotalMessage() = %v, want %v", gotPrint, tt.want)
+			}
+		})
+	}
+}
+
 func TestDeleteMessage(t *testing.T) {
 	type args struct {
 		id string
 		ts string
 	}
 
+	type want struct {
+		print    string
+		failures map[string]int
+	}
+
 	tests := []struct {
 		name   string
 		args   args
 		apiRes string
-		want   string
+		want   want
 	}{
 		{
 			name:   "ChatDeleteOk",
 			args:   args{id: "ABCDEF123", ts: "1503435956.000247"},
 			apiRes: "testdata/chatDelete/ok.json",
-			want:   "",
+			want:   want{print: "", failures: map[string]int{}},
 		},
 		{
 			name:   "ChatDeleteError",
 			args:   args{id: "ABCDEF123", ts: "1503435956.000247"},
 			apiRes: "testdata/chatDelete/error.json",
-			want:   "Can not delete message: ABCDEF123 : 1503435956.000247 : cant_delete_message",
+			want:   want{print: "Can not delete message: ABCDEF123 : 1503435956.000247 : cant_delete_message", failures: map[string]int{"cant_delete_message": 1}},
 		},
 		{
 			name:   "MessageNotFound",
 			args:   args{id: "ABCDEF123", ts: "1503435956.000247"},
 			apiRes: "testdata/chatDelete/messageNotFound.json",
-			want:   "Can not delete message: ABCDEF123 : 1503435956.000247 : message_not_found",
+			want:   want{print: "Can not delete message: ABCDEF123 : 1503435956.000247 : message_not_found", failures: map[string]int{"message_not_found": 1}},
 		},
 	}
 	for _, tt := range tests {
@@ -239,11 +296,21 @@ func TestDeleteMessage(t *testing.T) {
 				buf.Reset()
 			}()
 
-			(&SlackClient{client}).deleteMessage(tt.args.id, tt.args.ts)
+			stats := newRunStats()
+			(&SlackClient{Client: client, stats: stats}).deleteMessage(tt.args.id, tt.args.ts)
 
 			gotPrint := strings.TrimRight(buf.String(), "\n")
-			if gotPrint != tt.want {
-				t.Errorf("deleteMessage() = %v, want %v", gotPrint, tt.want)
+			if gotPrint != tt.want.print {
+				t.Errorf("deleteMessage() = %v, want %v", gotPrint, tt.want.print)
+			}
+			gotFailures := stats.failedByErrorByChannel[tt.args.id]
+			if len(gotFailures) != len(tt.want.failures) {
+				t.Errorf("deleteMessage() failures = %v, want %v", gotFailures, tt.want.failures)
+			}
+			for code, count := range tt.want.failures {
+				if gotFailures[code] != count {
+					t.Errorf("deleteMessage() failures[%q] = %v, want %v", code, gotFailures[code], count)
+				}
 			}
 		})
 	}
@@ -305,8 +372,11 @@ func TestLoopInAllChannels(t *testing.T) {
 		days     int
 	}
 	type want struct {
-		countByChannel map[string]int
-		print          string
+		countByChannel    map[string]int
+		print             string
+		protected         int
+		processedChannels int
+		skippedChannels   int
 	}
 
 	type apiRes struct {
@@ -324,37 +394,37 @@ func TestLoopInAllChannels(t *testing.T) {
 			name:   "AMessage",
 			args:   args{channels: []slack.Channel{{}}, now: time.Now(), days: 3},
 			apiRes: apiRes{conversationsHistory: "testdata/conversationsHistory/aMessage.json", conversationsReplies: "testdata/conversationsReplies/messages.json"},
-			want:   want{countByChannel: map[string]int{"": 1}, print: ""},
+			want:   want{countByChannel: map[string]int{"": 1}, print: "", processedChannels: 1},
 		},
 		{
 			name:   "TwoMessage",
 			args:   args{channels: []slack.Channel{{}}, now: time.Now(), days: 3},
 			apiRes: apiRes{conversationsHistory: "testdata/conversationsHistory/twoMessages.json", conversationsReplies: "testdata/conversationsReplies/messages.json"},
-			want:   want{countByChannel: map[string]int{"": 2}, print: ""},
+			want:   want{countByChannel: map[string]int{"": 2}, print: "", processedChannels: 1},
 		},
 		{
 			name:   "WithReaction",
 			args:   args{channels: []slack.Channel{{}}, now: time.Now(), days: 3},
 			apiRes: apiRes{conversationsHistory: "testdata/conversationsHistory/twoMessagesWithReaction.json", conversationsReplies: "testdata/conversationsReplies/messages.json"},
-			want:   want{countByChannel: map[string]int{"": 1}, print: ""},
+			want:   want{countByChannel: map[string]int{"": 1}, print: "", protected: 1, processedChannels: 1},
 		},
 		{
 			name:   "ConversationsHistoryError",
 			args:   args{channels: []slack.Channel{{}}, now: time.Now(), days: 3},
 			apiRes: apiRes{conversationsHistory: "testdata/conversationsHistory/error.json", conversationsReplies: "testdata/conversationsReplies/messages.json"},
-			want:   want{countByChannel: map[string]int{}, print: "Can not get history: channel_not_found"},
+			want:   want{countByChannel: map[string]int{}, print: "Can not get history: channel_not_found", skippedChannels: 1},
 		},
 		{
 			name:   "WithReplyOk",
 			args:   args{channels: []slack.Channel{{}}, now: time.Now(), days: 3},
 			apiRes: apiRes{conversationsHistory: "testdata/conversationsHistory/aMessageWithReply.json", conversationsReplies: "testdata/conversationsReplies/messages.json"},
-			want:   want{countByChannel: map[string]int{"": 3}, print: ""},
+			want:   want{countByChannel: map[string]int{"": 3}, print: "", processedChannels: 1},
 		},
 		{
 			name:   "WithReplyError",
 			args:   args{channels: []slack.Channel{{}}, now: time.Now(), days: 3},
 			apiRes: apiRes{conversationsHistory: "testdata/conversationsHistory/aMessageWithReply.json", conversationsReplies: "testdata/conversationsReplies/error.json"},
-			want:   want{countByChannel: map[string]int{"": 1}, print: "Can not get replies: thread_not_found"},
+			want:   want{countByChannel: map[string]int{"": 1}, print: "Can not get replies: thread_not_found", processedChannels: 1},
 		},
 	}
 	for _, tt := range tests {
@@ -387,7 +457,8 @@ func TestLoopInAllChannels(t *testing.T) {
 				buf.Reset()
 			}()
 
-			got := (&SlackClient{client}).loopInAllChannels(tt.args.channels, tt.args.now, tt.args.days)
+			stats := newRunStats()
+			got := (&SlackClient{Client: client, stats: stats}).loopInAllChannels(tt.args.channels, tt.args.now, tt.args.days)
 
 			if len(got) != len(tt.want.countByChannel) {
 				t.Errorf("loopInAllChannels() len = %v, want %v", len(got), len(tt.want.countByChannel))
@@ -401,6 +472,12 @@ func TestLoopInAllChannels(t *testing.T) {
 			if gotPrint != tt.want.print {
 				t.Errorf("loopInAllChannels() print = %v, want %v", gotPrint, tt.want.print)
 			}
+			if stats.protectedByReason["reaction"] != tt.want.protected {
+				t.Errorf("loopInAllChannels() protected = %v, want %v", stats.protectedByReason["reaction"], tt.want.protected)
+			}
+			if stats.processedChannels != tt.want.processedChannels || stats.skippedChannels != tt.want.skippedChannels {
+				t.Errorf("loopInAllChannels() channels = %v/%v, want %v/%v", stats.processedChannels, stats.skippedChannels, tt.want.processedChannels, tt.want.skippedChannels)
+			}
 		})
 	}
 }
@@ -411,8 +488,10 @@ func TestDeleteFiles(t *testing.T) {
 		days int
 	}
 	type want struct {
-		count int
-		print string
+		count      int
+		print      string
+		freedBytes int64
+		failures   map[string]map[string]int
 	}
 
 	type apiRes struct {
@@ -436,19 +515,25 @@ func TestDeleteFiles(t *testing.T) {
 			name:   "CanDeleteOneFile",
 			args:   args{now: time.Now(), days: 3},
 			apiRes: apiRes{files: "testdata/files/oneFile.json", deleteFile: "testdata/deleteFile/ok.json"},
-			want:   want{count: 1, print: ""},
+			want:   want{count: 1, print: "", freedBytes: 137531},
 		},
 		{
 			name:   "CanDeleteTwoFiles",
 			args:   args{now: time.Now(), days: 3},
 			apiRes: apiRes{files: "testdata/files/twoFiles.json", deleteFile: "testdata/deleteFile/ok.json"},
-			want:   want{count: 2, print: ""},
+			want:   want{count: 2, print: "", freedBytes: 282069},
 		},
 		{
 			name:   "CanNotDeleteTwoFiles",
 			args:   args{now: time.Now(), days: 3},
 			apiRes: apiRes{files: "testdata/files/twoFiles.json", deleteFile: "testdata/deleteFile/error.json"},
-			want:   want{count: 0, print: "Can not delete file: invalid_auth\nCan not delete file: invalid_auth"},
+			want:   want{count: 0, print: "Can not delete file: invalid_auth\nCan not delete file: invalid_auth", failures: map[string]map[string]int{"C0T8SE4AU": {"invalid_auth": 2}}},
+		},
+		{
+			name:   "CanNotDeleteUnsharedFile",
+			args:   args{now: time.Now(), days: 3},
+			apiRes: apiRes{files: "testdata/files/unsharedFile.json", deleteFile: "testdata/deleteFile/error.json"},
+			want:   want{count: 0, print: "Can not delete file: invalid_auth", failures: map[string]map[string]int{"none": {"invalid_auth": 1}}},
 		},
 	}
 	for _, tt := range tests {
@@ -477,7 +562,8 @@ func TestDeleteFiles(t *testing.T) {
 				buf.Reset()
 			}()
 
-			got := (&SlackClient{client}).deleteFiles(tt.args.now, tt.args.days)
+			stats := newRunStats()
+			got := (&SlackClient{Client: client, stats: stats}).deleteFiles(tt.args.now, tt.args.days)
 
 			if got != tt.want.count {
 				t.Errorf("deleteFiles() = %v, want %v", got, tt.want.count)
@@ -486,6 +572,274 @@ func TestDeleteFiles(t *testing.T) {
 			if gotPrint != tt.want.print {
 				t.Errorf("deleteFiles() = %v, want %v", gotPrint, tt.want.print)
 			}
+			if stats.freedBytes != tt.want.freedBytes {
+				t.Errorf("deleteFiles() freedBytes = %v, want %v", stats.freedBytes, tt.want.freedBytes)
+			}
+			if len(tt.want.failures) > 0 && fmt.Sprint(stats.failedByErrorByChannel) != fmt.Sprint(tt.want.failures) {
+				t.Errorf("deleteFiles() failures = %v, want %v", stats.failedByErrorByChannel, tt.want.failures)
+			}
+		})
+	}
+}
+
+func TestCall(t *testing.T) {
+	type want struct {
+		err     string
+		calls   int
+		retries int
+	}
+
+	tests := []struct {
+		name        string
+		rateLimited int
+		want        want
+	}{
+		{
+			name:        "NotRateLimited",
+			rateLimited: 0,
+			want:        want{err: "", calls: 1, retries: 0},
+		},
+		{
+			name:        "RateLimitedOnce",
+			rateLimited: 1,
+			want:        want{err: "", calls: 2, retries: 1},
+		},
+		{
+			name:        "RateLimitedTooManyTimes",
+			rateLimited: 10,
+			want:        want{err: "slack rate limit exceeded, retry after 0s", calls: 4, retries: 3},
+		},
+	}
+	for _, tt := range tests {
+		requested := 0
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/chat.delete", func(w http.ResponseWriter, _ *http.Request) {
+				requested++
+				if requested <= tt.rateLimited {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				res, _ := testdata.ReadFile("testdata/chatDelete/ok.json")
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		t.Run(tt.name, func(t *testing.T) {
+			t.Helper()
+
+			stats := newRunStats()
+			slackClient := &SlackClient{Client: client, stats: stats}
+			err := slackClient.call("chat.delete", func() error {
+				_, _, err := slackClient.DeleteMessage("ABCDEF123", "1503435956.000247")
+				return err
+			})
+
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("call() err = %v, want %v", gotErr, tt.want.err)
+			}
+			if stats.callsByMethod["chat.delete"] != tt.want.calls {
+				t.Errorf("call() calls = %v, want %v", stats.callsByMethod["chat.delete"], tt.want.calls)
+			}
+			if stats.retriesByMethod["chat.delete"] != tt.want.retries {
+				t.Errorf("call() retries = %v, want %v", stats.retriesByMethod["chat.delete"], tt.want.retries)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "SlackError",
+			err:  slack.SlackErrorResponse{Err: "cant_delete_message"},
+			want: "cant_delete_message",
+		},
+		{
+			name: "WrappedSlackError",
+			err:  fmt.Errorf("can not delete: %w", slack.SlackErrorResponse{Err: "message_not_found"}),
+			want: "message_not_found",
+		},
+		{
+			name: "RateLimited",
+			err:  &slack.RateLimitedError{RetryAfter: time.Second},
+			want: "ratelimited",
+		},
+		{
+			name: "Other",
+			err:  fmt.Errorf("connection refused"),
+			want: "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorCode(tt.err)
+			if got != tt.want {
+				t.Errorf("errorCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadWorkspaces(t *testing.T) {
+	type want struct {
+		workspaces []Workspace
+		err        string
+	}
+
+	tests := []struct {
+		name       string
+		configPath string
+		want       want
+	}{
+		{
+			name:       "NoConfig",
+			configPath: "",
+			want:       want{workspaces: []Workspace{{Name: "default", BotToken: "xoxb-env", UserToken: "xoxp-env", ChannelId: "CENV", Days: 5}}},
+		},
+		{
+			name:       "TwoWorkspaces",
+			configPath: "testdata/config/twoWorkspaces.json",
+			want: want{workspaces: []Workspace{
+				{Name: "workspaceA", BotToken: "xoxb-expanded", UserToken: "xoxp-user", ChannelId: "C0123456789", Days: 7},
+				{Name: "1", BotToken: "xoxb-bot", UserToken: "xoxp-user", ChannelId: "C9876543210", Days: 5, KeepFiles: true},
+			}},
+		},
+		{
+			name:       "NoWorkspaces",
+			configPath: "testdata/config/noWorkspaces.json",
+			want:       want{err: "no workspaces in config: testdata/config/noWorkspaces.json"},
+		},
+		{
+			name:       "InvalidJson",
+			configPath: "testdata/config/invalid.json",
+			want:       want{err: "can not decode config: unexpected EOF"},
+		},
+		{
+			name:       "NotExist",
+			configPath: "testdata/config/notExist.json",
+			want:       want{err: "can not open config: open testdata/config/notExist.json: no such file or directory"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SLACK_BOT_TOKEN", "xoxb-env")
+			t.Setenv("SLACK_USER_TOKEN", "xoxp-env")
+			t.Setenv("SLACK_CHANNEL_ID", "CENV")
+			t.Setenv("DAYS", "5")
+			t.Setenv("TEST_BOT_TOKEN", "xoxb-expanded")
+
+			got, err := loadWorkspaces(tt.configPath)
+
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("loadWorkspaces() err = %v, want %v", gotErr, tt.want.err)
+			}
+			if len(got) != len(tt.want.workspaces) {
+				t.Fatalf("loadWorkspaces() = %v, want %v", got, tt.want.workspaces)
+			}
+			for i, workspace := range tt.want.workspaces {
+				if got[i] != workspace {
+					t.Errorf("loadWorkspaces()[%d] = %v, want %v", i, got[i], workspace)
+				}
+			}
+		})
+	}
+}
+
+func TestRunWorkspace(t *testing.T) {
+	type want struct {
+		messageCount int
+		fileCount    int
+		err          string
+	}
+
+	tests := []struct {
+		name      string
+		workspace Workspace
+		apiRes    string
+		want      want
+	}{
+		{
+			name:      "Ok",
+			workspace: Workspace{Name: "ok", ChannelId: "ABCDEF123", Days: 3},
+			apiRes:    "testdata/usersConversations/channelIsNotNil.json",
+			want:      want{messageCount: 1, fileCount: 2},
+		},
+		{
+			name:      "KeepFiles",
+			workspace: Workspace{Name: "keepFiles", ChannelId: "ABCDEF123", Days: 3, KeepFiles: true},
+			apiRes:    "testdata/usersConversations/channelIsNotNil.json",
+			want:      want{messageCount: 1, fileCount: 0},
+		},
+		{
+			name:      "CanNotGetChannels",
+			workspace: Workspace{Name: "error", ChannelId: "ABCDEF123", Days: 3},
+			apiRes:    "testdata/usersConversations/error.json",
+			want:      want{err: "can not get channels: invalid_auth"},
+		},
+	}
+	for _, tt := range tests {
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/users.conversations", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile(tt.apiRes)
+				w.Write(res)
+			})
+			c.Handle("/conversations.history", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/conversationsHistory/aMessage.json")
+				w.Write(res)
+			})
+			c.Handle("/chat.delete", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/chatDelete/ok.json")
+				w.Write(res)
+			})
+			c.Handle("/files.list", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/files/twoFiles.json")
+				w.Write(res)
+			})
+			c.Handle("/files.delete", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/deleteFile/ok.json")
+				w.Write(res)
+			})
+			c.Handle("/chat.postMessage", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/chatPostMessage/ok.json")
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		t.Run(tt.name, func(t *testing.T) {
+			t.Helper()
+
+			got := runWorkspace(tt.workspace, slack.OptionAPIURL(ts.GetAPIURL()))
+
+			if got.name != tt.workspace.Name {
+				t.Errorf("runWorkspace() name = %v, want %v", got.name, tt.workspace.Name)
+			}
+			if got.messageCount() != tt.want.messageCount {
+				t.Errorf("runWorkspace() messageCount = %v, want %v", got.messageCount(), tt.want.messageCount)
+			}
+			if got.fileCount != tt.want.fileCount {
+				t.Errorf("runWorkspace() fileCount = %v, want %v", got.fileCount, tt.want.fileCount)
+			}
+			gotErr := ""
+			if got.err != nil {
+				gotErr = got.err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("runWorkspace() err = %v, want %v", gotErr, tt.want.err)
+			}
 		})
 	}
 }
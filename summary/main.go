@@ -1,13 +1,37 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
 	"log"
+	"maps"
+	"math"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"text/template"
 	"time"
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/slack-go/slack"
 	"go.opentelemetry.io/otel"
@@ -16,119 +40,2915 @@ import (
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	"go.opentelemetry.io/otel/sdk/resource"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 )
 
 type config struct {
-	userClient *slack.Client
-	now        time.Time
-	yesterDay  time.Time
+	userClient     *slack.Client
+	period         period
+	withReplies    bool
+	history        map[string]HistoryRecord
+	trendThreshold float64
+	shorteners     map[string]string
+	// foldSubdomains counts feeds.example.com and blog.example.com as example.com.
+	foldSubdomains bool
+	siteByHost     map[string]string
+	users          *userCache
+	anonymize      bool
+	// a feed is stalled when it stays below stallRatio of its baseline for stallDays days.
+	stallDays  int
+	stallRatio float64
+	inactive   inactivePolicy
+	// owner enables the personal digest of the owner of the user token when it is not nil.
+	owner *owner
+	// responseChannels are the channels whose threads are timed, a thread is resolved by a reaction of resolvedEmoji.
+	responseChannels map[string]bool
+	resolvedEmoji    string
+	queryByName      map[string]string
 }
 
+// owner is the user of the user token, an empty questionChannels monitors every channel.
+type owner struct {
+	userID           string
+	groupIDs         []string
+	questionAge      time.Duration
+	questionChannels map[string]bool
+	now              time.Time
+}
+
+type personalItem struct {
+	channelID string
+	ts        string
+	text      string
+	permalink string
+}
+
+const MAX_PERSONAL_ITEMS = 20
+
+const DEFAULT_QUESTION_HOURS = 24
+
+const SNIPPET_LENGTH = 80
+
+// inactivePolicy finds the channels without messages for days days. action is "archive" or "leave" with the user token,
+// it is applied only when apply is true and only to channels with at most maxMembers members when maxMembers is positive.
+type inactivePolicy struct {
+	days       int
+	action     string
+	maxMembers int
+	apply      bool
+}
+
+type inactiveChannel struct {
+	channelID string
+	name      string
+	members   int
+	// checked is true once conversations.info confirmed the members, the policy is applied only to checked channels.
+	checked      bool
+	lastActivity time.Time
+	action       string
+	done         bool
+}
+
+// period is the window summarized by one run, from is inclusive and to is exclusive.
+type period struct {
+	name string
+	from time.Time
+	to   time.Time
+}
+
+type result struct {
+	countBySiteByChannel map[string]map[string]int
+	// countByHumanByChannel counts the messages of the members by user ID, the names are resolved in the report.
+	countByHumanByChannel map[string]map[string]int
+	countByHostByChannel  map[string]map[string]int
+	countByChannel        map[string]int
+	countByDayByChannel   map[string]map[string]int
+	// countByHourByChannel buckets the messages by the hour of day in the location of the period.
+	countByHourByChannel         map[string][]int
+	countByLinkByChannel         map[string]map[string]int
+	channelsByLink               map[string][]string
+	threads                      []thread
+	countByEmojiByChannel        map[string]map[string]int
+	countReactionByHostByChannel map[string]map[string]int
+	reacted                      []reacted
+	articlesByChannel            map[string][]article
+	mentions                     []personalItem
+	questions                    []personalItem
+	responsesByChannel           map[string]*responses
+	trackers                     []tracker
+}
+
+type tracker struct {
+	name    string
+	query   string
+	hits    int
+	matches []personalItem
+}
+
+const MAX_TRACKER_MATCHES = 3
+
+// SEARCH_COUNT is the largest page search.messages accepts.
+const SEARCH_COUNT = 100
+
+// unanswered counts the threads without a reply by someone else than the author.
+type responses struct {
+	threads    int
+	unanswered int
+	firstReply []time.Duration
+	resolved   []time.Duration
+}
+
+const DEFAULT_RESOLVED_EMOJI = "white_check_mark"
+
+type thread struct {
+	channelID  string
+	ts         string
+	replyCount int
+	permalink  string
+}
+
+const MAX_THREADS = 5
+
+type reacted struct {
+	channelID     string
+	ts            string
+	reactionCount int
+	permalink     string
+}
+
+const MAX_REACTED = 5
+
+const MAX_EMOJI = 10
+
+const MAX_DUPLICATES = 5
+
+// HistoryRecord is one line of the history store.
+type HistoryRecord struct {
+	Date                   string                    `json:"date"`
+	CountByChannel         map[string]int            `json:"count_by_channel"`
+	CountByAuthorByChannel map[string]map[string]int `json:"count_by_author_by_channel"`
+	CountByHostByChannel   map[string]map[string]int `json:"count_by_host_by_channel"`
+	CountByLinkByChannel   map[string]map[string]int `json:"count_by_link_by_channel,omitempty"`
+}
+
+const DEFAULT_TREND_THRESHOLD = 50.0
+
+type CachedUser struct {
+	Name      string    `json:"name"`
+	Bot       bool      `json:"bot"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+type userCache struct {
+	client *slack.Client
+	path   string
+	ttl    time.Duration
+	users  map[string]CachedUser
+	// listed is true once users.list has been read in this run, later misses ask users.info.
+	listed  bool
+	changed bool
+}
+
+const DEFAULT_USER_CACHE_TTL = 24 * time.Hour
+
+type feed struct {
+	channelID string
+	author    string
+	baseline  float64
+	recent    []int
+	stalled   bool
+}
+
+const (
+	DEFAULT_STALL_DAYS  = 3
+	DEFAULT_STALL_RATIO = 0.2
+	// the baseline is the average of the recorded days in the BASELINE_DAYS days before the stall window,
+	// a feed with less than MIN_BASELINE_DAYS recorded days is not judged.
+	BASELINE_DAYS     = 28
+	MIN_BASELINE_DAYS = 7
+	// MIN_STALL_EXPECTED is the least number of messages a feed is expected to post in the stallDays days to be judged.
+	MIN_STALL_EXPECTED = 1
+)
+
+// Report is the model given to the message templates, and the JSON written to SUMMARY_JSON_PATH and SUMMARY_WEBHOOK_URL.
+type Report struct {
+	Period       ReportPeriod    `json:"period"`
+	Total        int             `json:"total"`
+	DayOverDay   Trend           `json:"day_over_day"`
+	WeekOverWeek Trend           `json:"week_over_week"`
+	Channels     []ChannelReport `json:"channels"`
+	Hourly       []int           `json:"hourly"`
+	Authors      []Count         `json:"authors"`
+	Humans       []Count         `json:"humans"`
+	Hosts        []Count         `json:"hosts"`
+	Links        []Count         `json:"links"`
+	Threads      []ThreadReport  `json:"threads"`
+	Duplicates   []Duplicate     `json:"duplicates"`
+	Reacted      []ReactedReport `json:"reacted"`
+	Emoji        []Count         `json:"emoji"`
+	Trackers     []TrackerReport `json:"trackers"`
+}
+
+type ReportPeriod struct {
+	Name  string      `json:"name"`
+	From  time.Time   `json:"from"`
+	To    time.Time   `json:"to"`
+	Title []string    `json:"title"`
+	Days  []time.Time `json:"days"`
+}
+
+type ChannelReport struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Total        int    `json:"total"`
+	DayOverDay   Trend  `json:"day_over_day"`
+	WeekOverWeek Trend  `json:"week_over_week"`
+	Flagged      bool   `json:"flagged"`
+	Daily        []int  `json:"daily"`
+	Hourly       []int  `json:"hourly"`
+	// Authors are the bots and the feeds, Humans are the members of the workspace.
+	Authors   []Count         `json:"authors"`
+	Humans    []Count         `json:"humans"`
+	Hosts     []Count         `json:"hosts"`
+	Links     []Count         `json:"links"`
+	Reactions int             `json:"reactions"`
+	Emoji     []Count         `json:"emoji"`
+	Response  *ResponseReport `json:"response,omitempty"`
+}
+
+// The durations of ResponseReport are in nanoseconds in JSON.
+type ResponseReport struct {
+	Threads          int           `json:"threads"`
+	Unanswered       int           `json:"unanswered"`
+	FirstReplyMedian time.Duration `json:"first_reply_median_ns"`
+	FirstReplyP90    time.Duration `json:"first_reply_p90_ns"`
+	Resolved         int           `json:"resolved"`
+	ResolvedMedian   time.Duration `json:"resolved_median_ns"`
+	ResolvedP90      time.Duration `json:"resolved_p90_ns"`
+}
+
+type Count struct {
+	Name         string `json:"name"`
+	Count        int    `json:"count"`
+	DayOverDay   Trend  `json:"day_over_day"`
+	WeekOverWeek Trend  `json:"week_over_week"`
+}
+
+type Trend struct {
+	Known    bool    `json:"known"`
+	Previous int     `json:"previous"`
+	Change   int     `json:"change"`
+	Percent  float64 `json:"percent"`
+}
+
+type Duplicate struct {
+	Link     string   `json:"link"`
+	Channels []string `json:"channels"`
+}
+
+type TrackerReport struct {
+	Name    string        `json:"name"`
+	Query   string        `json:"query"`
+	Hits    int           `json:"hits"`
+	Matches []MatchReport `json:"matches"`
+}
+
+type MatchReport struct {
+	ChannelID string `json:"channel_id"`
+	Text      string `json:"text"`
+	Permalink string `json:"permalink"`
+}
+
+type ReactedReport struct {
+	ChannelID string `json:"channel_id"`
+	Reactions int    `json:"reactions"`
+	Permalink string `json:"permalink"`
+}
+
+type ThreadReport struct {
+	ChannelID  string `json:"channel_id"`
+	ReplyCount int    `json:"reply_count"`
+	Permalink  string `json:"permalink"`
+}
+
+const DEFAULT_TEMPLATE = `{{range .Period.Title}}{{.}}
+{{end}}{{.Total}}{{template "trends" .}}
+{{range .Channels}}
+<#{{.ID}}>{{template "trends" .}}{{if .Flagged}} ⚠{{end}}
+{{if gt (len $.Period.Days) 1}}daily : {{join .Daily " / "}}
+{{end}}{{with hours .Hourly}}hours : {{.}}
+{{end}}{{range .Authors}}{{.Name}} : {{.Count}}{{template "trends" .}}
+{{end}}{{with .Reactions}}reactions : {{.}}
+{{end}}{{with response .Response}}response : {{.}}
+{{end}}{{with .Humans}}humans : {{range $i, $human := .}}{{if $i}} / {{end}}{{$human.Name}} {{$human.Count}}{{end}}
+{{end}}{{with .Hosts}}sites : {{range $i, $host := .}}{{if $i}} / {{end}}{{$host.Name}} {{$host.Count}}{{end}}
+{{end}}{{end}}{{if .Threads}}
+Most discussed threads
+{{range .Threads}}<#{{.ChannelID}}> : {{.ReplyCount}} replies{{if .Permalink}} {{.Permalink}}{{end}}
+{{end}}{{end}}{{if .Duplicates}}
+Duplicate articles
+{{range .Duplicates}}{{.Link}} : posted in {{len .Channels}} channels
+{{end}}{{end}}{{if .Reacted}}
+Most reacted messages
+{{range .Reacted}}<#{{.ChannelID}}> : {{.Reactions}} reactions{{if .Permalink}} {{.Permalink}}{{end}}
+{{end}}{{end}}{{if .Emoji}}
+Top emoji
+{{range $i, $emoji := .Emoji}}{{if $i}} / {{end}}:{{$emoji.Name}}: {{$emoji.Count}}{{end}}
+{{end}}{{if .Trackers}}
+Keyword trackers
+{{range .Trackers}}{{.Name}} : {{.Hits}} hits
+{{range .Matches}}<#{{.ChannelID}}> {{snippet .Text}}{{if .Permalink}} {{.Permalink}}{{end}}
+{{end}}{{end}}{{end}}{{define "trends"}}{{with trend .DayOverDay}} d/d {{.}}{{end}}{{with trend .WeekOverWeek}} w/w {{.}}{{end}}{{end}}`
+
+const MARKDOWN_TEMPLATE = `# {{range $i, $t := .Period.Title}}{{if $i}} {{end}}{{$t}}{{end}}
+
+Total: {{.Total}}{{template "trends" .}}
+{{range .Channels}}
+## #{{.Name}}
+
+Total: {{.Total}}{{template "trends" .}}
+{{if gt (len $.Period.Days) 1}}
+Daily: {{join .Daily " / "}}
+{{end}}{{with response .Response}}
+Response: {{.}}
+{{end}}{{with .Authors}}
+| Author | Count |
+| --- | --- |
+{{range .}}| {{cell .Name}} | {{.Count}} |
+{{end}}{{end}}{{with .Humans}}
+| Human | Count |
+| --- | --- |
+{{range .}}| {{cell .Name}} | {{.Count}} |
+{{end}}{{end}}{{with .Hosts}}
+| Site | Count |
+| --- | --- |
+{{range .}}| {{cell .Name}} | {{.Count}} |
+{{end}}{{end}}{{end}}{{if .Threads}}
+## Most discussed threads
+
+{{range .Threads}}- {{if .Permalink}}[{{.ReplyCount}} replies]({{.Permalink}}){{else}}{{.ReplyCount}} replies{{end}}
+{{end}}{{end}}{{if .Duplicates}}
+## Duplicate articles
+
+{{range .Duplicates}}- <{{.Link}}> posted in {{len .Channels}} channels
+{{end}}{{end}}{{if .Trackers}}
+## Keyword trackers
+
+{{range .Trackers}}- {{.Name}}: {{.Hits}} hits
+{{range .Matches}}  - {{if .Permalink}}[{{snippet .Text}}]({{.Permalink}}){{else}}{{snippet .Text}}{{end}}
+{{end}}{{end}}{{end}}{{define "trends"}}{{with trend .DayOverDay}} d/d {{.}}{{end}}{{with trend .WeekOverWeek}} w/w {{.}}{{end}}{{end}}`
+
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		if err := runHistoryCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Println("can not print history:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	userClient := slack.New(os.Getenv("SLACK_USER_TOKEN"))
 
-	now := time.Now()
-	yesterDay := now.AddDate(0, 0, -1)
+	location, err := makeLocation(os.Getenv("SUMMARY_TZ"))
+	if err != nil {
+		log.Println("can not load location:", err)
+		return
+	}
+	p, err := makePeriod(os.Getenv("PERIOD"), os.Getenv("PERIOD_FROM"), os.Getenv("PERIOD_TO"), time.Now().In(location))
+	if err != nil {
+		log.Println("can not make period:", err)
+		return
+	}
+
+	trendThreshold := DEFAULT_TREND_THRESHOLD
+	if threshold := os.Getenv("SUMMARY_TREND_THRESHOLD"); threshold != "" {
+		trendThreshold, err = strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			log.Println("env SUMMARY_TREND_THRESHOLD is invalid:", err)
+			trendThreshold = DEFAULT_TREND_THRESHOLD
+		}
+	}
+	historyPath := os.Getenv("SUMMARY_HISTORY_PATH")
+	history, err := loadHistory(historyPath)
+	if err != nil {
+		log.Println("can not load history:", err)
+	}
+
+	shorteners, err := loadShorteners(os.Getenv("SUMMARY_SHORTENERS"))
+	if err != nil {
+		log.Println("can not load shorteners:", err)
+	}
+
+	siteByHost, err := loadSiteAliases(os.Getenv("SUMMARY_SITE_ALIASES"))
+	if err != nil {
+		log.Println("can not load site aliases:", err)
+	}
+
+	userCacheTTL := DEFAULT_USER_CACHE_TTL
+	if ttl := os.Getenv("SUMMARY_USER_CACHE_TTL"); ttl != "" {
+		userCacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Println("env SUMMARY_USER_CACHE_TTL is invalid:", err)
+			userCacheTTL = DEFAULT_USER_CACHE_TTL
+		}
+	}
+	users, err := loadUserCache(userClient, os.Getenv("SUMMARY_USER_CACHE_PATH"), userCacheTTL)
+	if err != nil {
+		log.Println("can not load user cache:", err)
+	}
+
+	stallDays := DEFAULT_STALL_DAYS
+	if days := os.Getenv("SUMMARY_STALL_DAYS"); days != "" {
+		stallDays, err = strconv.Atoi(days)
+		if err != nil || stallDays < 1 {
+			log.Println("env SUMMARY_STALL_DAYS is invalid:", days)
+			stallDays = DEFAULT_STALL_DAYS
+		}
+	}
+	stallRatio := DEFAULT_STALL_RATIO
+	if ratio := os.Getenv("SUMMARY_STALL_RATIO"); ratio != "" {
+		stallRatio, err = strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			log.Println("env SUMMARY_STALL_RATIO is invalid:", err)
+			stallRatio = DEFAULT_STALL_RATIO
+		}
+	}
+
+	inactive := inactivePolicy{action: os.Getenv("SUMMARY_INACTIVE_ACTION"), apply: os.Getenv("SUMMARY_INACTIVE_APPLY") == "true"}
+	if days := os.Getenv("SUMMARY_INACTIVE_DAYS"); days != "" {
+		inactive.days, err = strconv.Atoi(days)
+		if err != nil {
+			log.Println("env SUMMARY_INACTIVE_DAYS is invalid:", err)
+		}
+	}
+	if members := os.Getenv("SUMMARY_INACTIVE_MAX_MEMBERS"); members != "" {
+		inactive.maxMembers, err = strconv.Atoi(members)
+		if err != nil {
+			log.Println("env SUMMARY_INACTIVE_MAX_MEMBERS is invalid:", err)
+		}
+	}
+	if inactive.action != "" && inactive.action != "archive" && inactive.action != "leave" {
+		log.Println("env SUMMARY_INACTIVE_ACTION is invalid:", inactive.action)
+		inactive.action = ""
+	}
+
+	var o *owner
+	if os.Getenv("SUMMARY_PERSONAL") == "true" {
+		questionHours := DEFAULT_QUESTION_HOURS
+		if hours := os.Getenv("SUMMARY_QUESTION_HOURS"); hours != "" {
+			questionHours, err = strconv.Atoi(hours)
+			if err != nil {
+				log.Println("env SUMMARY_QUESTION_HOURS is invalid:", err)
+				questionHours = DEFAULT_QUESTION_HOURS
+			}
+		}
+		questionChannels := splitIDs(os.Getenv("SUMMARY_QUESTION_CHANNELS"))
+		o, err = resolveOwner(userClient, time.Duration(questionHours)*time.Hour, questionChannels, time.Now())
+		if err != nil {
+			log.Println("can not resolve owner:", err)
+		}
+	}
+
+	responseChannels := splitIDs(os.Getenv("SUMMARY_RESPONSE_CHANNELS"))
+	resolvedEmoji := strings.Trim(os.Getenv("SUMMARY_RESOLVED_EMOJI"), ":")
+	if resolvedEmoji == "" {
+		resolvedEmoji = DEFAULT_RESOLVED_EMOJI
+	}
+
+	queryByName, err := loadStringMap(os.Getenv("SUMMARY_QUERIES"))
+	if err != nil {
+		log.Println("can not load queries:", err)
+	}
+
+	c := &config{
+		userClient:       userClient,
+		period:           p,
+		withReplies:      os.Getenv("SUMMARY_REPLIES") == "true",
+		history:          history,
+		trendThreshold:   trendThreshold,
+		shorteners:       shorteners,
+		foldSubdomains:   os.Getenv("SUMMARY_FOLD_SUBDOMAINS") == "true",
+		siteByHost:       siteByHost,
+		users:            users,
+		anonymize:        os.Getenv("SUMMARY_ANONYMIZE") == "true",
+		stallDays:        stallDays,
+		stallRatio:       stallRatio,
+		inactive:         inactive,
+		owner:            o,
+		responseChannels: responseChannels,
+		resolvedEmoji:    resolvedEmoji,
+		queryByName:      queryByName,
+	}
+	conversations := c.getConversationsForUser()
+
+	channelById := map[string]slack.Channel{}
+	for _, channel := range conversations {
+		channelById[channel.ID] = channel
+	}
+
+	r := c.makeResult(conversations)
+	report := c.makeReport(r, channelById)
+	if err := users.save(); err != nil {
+		log.Println("can not save user cache:", err)
+	}
+	if historyPath != "" && p.name == "day" {
+		if err := appendHistory(historyPath, c.makeHistoryRecord(r)); err != nil {
+			log.Println("can not save history:", err)
+		}
+	}
+
+	// the outputs are written before posting, so that they are kept even when Slack fails
+	writeOutputs(report, os.Getenv("SUMMARY_MARKDOWN_PATH"), os.Getenv("SUMMARY_JSON_PATH"), os.Getenv("SUMMARY_WEBHOOK_URL"))
+
+	message := createMessage(report)
+	blocks := createBlocks(report)
+	templated := false
+	if templatePath := os.Getenv("SUMMARY_TEMPLATE"); templatePath != "" {
+		text, templateBlocks, err := renderTemplateFile(templatePath, report)
+		templated = err == nil
+		if err != nil {
+			log.Println("can not render template:", err)
+		} else if templateBlocks != nil {
+			blocks = templateBlocks
+		} else {
+			message, blocks = text, nil
+		}
+	}
+	botClient := slack.New(os.Getenv("SLACK_BOT_TOKEN"))
+	perReply := 1
+	if n := os.Getenv("SUMMARY_CHANNELS_PER_REPLY"); n != "" {
+		perReply, err = strconv.Atoi(n)
+		if err != nil {
+			log.Println("env SUMMARY_CHANNELS_PER_REPLY is invalid:", err)
+			perReply = 1
+		}
+	}
+	ts, err := postReport(botClient, os.Getenv("SLACK_CHANNEL_ID"), message, blocks, report, os.Getenv("SUMMARY_SPLIT") == "true", perReply, templated)
+	if err != nil {
+		log.Println("can not post:", err)
+	} else {
+		if os.Getenv("SUMMARY_CHARTS") == "true" {
+			c.uploadCharts(botClient, os.Getenv("SLACK_CHANNEL_ID"), ts, report, r)
+		}
+		if os.Getenv("SUMMARY_DIGEST") == "true" {
+			postDigests(botClient, os.Getenv("SLACK_CHANNEL_ID"), ts, r, channelById)
+		}
+	}
+	if c.owner != nil {
+		if text := createPersonalMessage(r); text != "" {
+			if err := postPersonalMessage(botClient, c.owner.userID, text); err != nil {
+				log.Println("can not post personal digest:", err)
+			}
+		}
+	}
+	if c.inactive.days > 0 && p.name == "week" {
+		channels := c.applyInactivePolicy(c.findInactiveChannels(conversations, time.Now()), os.Getenv("SLACK_CHANNEL_ID"))
+		if text := c.createInactiveMessage(channels); text != "" {
+			if _, _, err := botClient.PostMessage(os.Getenv("SLACK_CHANNEL_ID"), slack.MsgOptionText(text, false)); err != nil {
+				log.Println("can not post inactive channels:", err)
+			}
+		}
+	}
+	feeds := c.feedHealth(r)
+	if alert := createStalledMessage(feeds); alert != "" {
+		if _, _, err := botClient.PostMessage(os.Getenv("SLACK_CHANNEL_ID"), slack.MsgOptionText(alert, false)); err != nil {
+			log.Println("can not post stalled feeds:", err)
+		}
+	}
+	countByHostByChannel := r.countByHostByChannel
+	if os.Getenv("SUMMARY_EXCLUDE_DUPLICATES") == "true" {
+		countByHostByChannel = c.uniqueCountByHostByChannel(r)
+	}
+	sendMetrics(r, countByHostByChannel, feeds, channelById, p)
+}
+
+func splitIDs(value string) map[string]bool {
+	ids := map[string]bool{}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// The local time zone of the machine is used when name is empty.
+func makeLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// addDays returns the first moment of the day n days after day in the location of day.
+func addDays(day time.Time, n int) time.Time {
+	noon := time.Date(day.Year(), day.Month(), day.Day()+n, 12, 0, 0, 0, day.Location())
+	midnight := time.Date(noon.Year(), noon.Month(), noon.Day(), 0, 0, 0, 0, noon.Location())
+	if midnight.Hour() != 0 {
+		// DST skipped the midnight, so the day begins when the zone changes
+		start, end := midnight.ZoneBounds()
+		if midnight.Day() != noon.Day() {
+			return end
+		}
+		return start
+	}
+	return midnight
+}
+
+// makePeriod returns the window to summarize. name is one of day (yesterday), week (the last ISO week),
+// month (the last calendar month) or custom (from and to, both inclusive, formatted as 2006-01-02).
+func makePeriod(name, from, to string, now time.Time) (period, error) {
+	today := addDays(now, 0)
+	switch name {
+	case "", "day":
+		return period{name: "day", from: addDays(today, -1), to: today}, nil
+	case "week":
+		monday := addDays(today, -(int(today.Weekday())+6)%7)
+		return period{name: "week", from: addDays(monday, -7), to: monday}, nil
+	case "month":
+		firstDay := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+		return period{name: "month", from: time.Date(today.Year(), today.Month()-1, 1, 0, 0, 0, 0, today.Location()), to: firstDay}, nil
+	case "custom":
+		fromDay, err := time.ParseInLocation("2006-01-02", from, now.Location())
+		if err != nil {
+			return period{}, fmt.Errorf("PERIOD_FROM is invalid: %w", err)
+		}
+		toDay, err := time.ParseInLocation("2006-01-02", to, now.Location())
+		if err != nil {
+			return period{}, fmt.Errorf("PERIOD_TO is invalid: %w", err)
+		}
+		if toDay.Before(fromDay) {
+			return period{}, fmt.Errorf("PERIOD_TO %s is before PERIOD_FROM %s", to, from)
+		}
+		return period{name: "custom", from: addDays(fromDay, 0), to: addDays(toDay, 1)}, nil
+	}
+	return period{}, fmt.Errorf("PERIOD %s is not one of day, week, month or custom", name)
+}
+
+func (p period) days() []time.Time {
+	days := []time.Time{}
+	for day := p.from; day.Before(p.to); day = addDays(day, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+func tsToTime(ts string) time.Time {
+	sec, nsec, _ := strings.Cut(ts, ".")
+	s, _ := strconv.ParseInt(sec, 10, 64)
+	n, _ := strconv.ParseInt((nsec + "000000000")[:9], 10, 64)
+	return time.Unix(s, n)
+}
+
+// loadHistory reads the history store, a JSON Lines file. A later line of the same date wins.
+// An empty path or a missing file is an empty history.
+func loadHistory(path string) (map[string]HistoryRecord, error) {
+	history := map[string]HistoryRecord{}
+	if path == "" {
+		return history, nil
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return history, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return history, fmt.Errorf("line %d: %w", line, err)
+		}
+		history[record.Date] = record
+	}
+	return history, scanner.Err()
+}
+
+func appendHistory(path string, record HistoryRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (c *config) makeHistoryRecord(r result) HistoryRecord {
+	return HistoryRecord{
+		Date:                   c.period.from.Format("2006-01-02"),
+		CountByChannel:         r.countByChannel,
+		CountByAuthorByChannel: r.countBySiteByChannel,
+		CountByHostByChannel:   r.countByHostByChannel,
+		CountByLinkByChannel:   r.countByLinkByChannel,
+	}
+}
+
+// runHistoryCommand prints the history store, e.g. `summary history -from 2023-01-01 -by host -format csv`.
+func runHistoryCommand(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(w)
+	path := flags.String("path", os.Getenv("SUMMARY_HISTORY_PATH"), "history store")
+	from := flags.String("from", "", "first date to print, 2006-01-02")
+	to := flags.String("to", "", "last date to print, 2006-01-02")
+	by := flags.String("by", "channel", "channel, author, host or link")
+	format := flags.String("format", "table", "table or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("-path or SUMMARY_HISTORY_PATH is required")
+	}
+	if *by != "channel" && *by != "author" && *by != "host" && *by != "link" {
+		return fmt.Errorf("-by %s is not one of channel, author, host or link", *by)
+	}
+	if *format != "table" && *format != "csv" {
+		return fmt.Errorf("-format %s is not one of table or csv", *format)
+	}
+	history, err := loadHistory(*path)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"date", "channel", *by, "count"}
+	if *by == "channel" {
+		header = []string{"date", "channel", "count"}
+	}
+	dates := []string{}
+	for date := range history {
+		if (*from == "" || date >= *from) && (*to == "" || date <= *to) {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+	rows := [][]string{}
+	for _, date := range dates {
+		record := history[date]
+		var countByKeyByChannel map[string]map[string]int
+		switch *by {
+		case "channel":
+			for _, channel := range sortByCount(record.CountByChannel) {
+				rows = append(rows, []string{date, channel, strconv.Itoa(record.CountByChannel[channel])})
+			}
+			continue
+		case "author":
+			countByKeyByChannel = record.CountByAuthorByChannel
+		case "host":
+			countByKeyByChannel = record.CountByHostByChannel
+		case "link":
+			countByKeyByChannel = record.CountByLinkByChannel
+		}
+		channels := []string{}
+		for channel := range countByKeyByChannel {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+		for _, channel := range channels {
+			for _, key := range sortByCount(countByKeyByChannel[channel]) {
+				rows = append(rows, []string{date, channel, key, strconv.Itoa(countByKeyByChannel[channel][key])})
+			}
+		}
+	}
+
+	if *format == "csv" {
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		return writer.WriteAll(rows)
+	}
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+func makeTrend(count, previous int, known bool) Trend {
+	if !known {
+		return Trend{}
+	}
+	trend := Trend{Known: true, Previous: previous, Change: count - previous}
+	if previous > 0 {
+		trend.Percent = float64(trend.Change) / float64(previous) * 100
+	}
+	return trend
+}
+
+// formatTrend returns e.g. "▲20 (+20%)", or "" when there is nothing to compare with.
+func formatTrend(trend Trend) string {
+	if !trend.Known {
+		return ""
+	}
+	mark := "±"
+	if trend.Change > 0 {
+		mark = "▲"
+	} else if trend.Change < 0 {
+		mark = "▼"
+	}
+	text := mark + strconv.Itoa(int(math.Abs(float64(trend.Change))))
+	if trend.Previous == 0 {
+		if trend.Change != 0 {
+			text += " (new)"
+		}
+		return text
+	}
+	if trend.Change == 0 {
+		return text + " (0%)"
+	}
+	return text + fmt.Sprintf(" (%+.0f%%)", trend.Percent)
+}
+
+func isUnusual(trend Trend, threshold float64) bool {
+	if !trend.Known || trend.Change == 0 {
+		return false
+	}
+	return trend.Previous == 0 || math.Abs(trend.Percent) >= threshold
+}
+
+// previousRecords returns the records of the day before and the same weekday a week before.
+// Only a daily summary is compared.
+func (c *config) previousRecords() (HistoryRecord, bool, HistoryRecord, bool) {
+	if c.period.name != "day" {
+		return HistoryRecord{}, false, HistoryRecord{}, false
+	}
+	dayBefore, dayOk := c.history[addDays(c.period.from, -1).Format("2006-01-02")]
+	weekBefore, weekOk := c.history[addDays(c.period.from, -7).Format("2006-01-02")]
+	return dayBefore, dayOk, weekBefore, weekOk
+}
+
+func (c *config) getConversationsForUser() []slack.Channel {
+	conversations, _, err := c.userClient.GetConversationsForUser(&slack.GetConversationsForUserParameters{})
+	if err != nil {
+		log.Println("can not get channels:", err)
+	}
+	return conversations
+}
+
+func (c *config) getConversationHistory(channelID, latest, oldest string) ([]slack.Message, error) {
+	messages := []slack.Message{}
+	cursor := ""
+	for {
+		params := slack.GetConversationHistoryParameters{ChannelID: channelID, Limit: 1000, Latest: latest, Oldest: oldest, Cursor: cursor}
+		conversationHistory, err := c.userClient.GetConversationHistory(&params)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, conversationHistory.Messages...)
+		cursor = conversationHistory.ResponseMetaData.NextCursor
+		if !conversationHistory.HasMore || cursor == "" {
+			return messages, nil
+		}
+	}
+}
+
+// getConversationReplies returns the replies of the thread posted before latest, without its parent.
+func (c *config) getConversationReplies(channelID, ts, latest string) ([]slack.Message, error) {
+	replies := []slack.Message{}
+	cursor := ""
+	for {
+		params := slack.GetConversationRepliesParameters{ChannelID: channelID, Timestamp: ts, Latest: latest, Limit: 1000, Cursor: cursor}
+		messages, hasMore, nextCursor, err := c.userClient.GetConversationReplies(&params)
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			if message.Msg.Timestamp == ts {
+				continue
+			}
+			replies = append(replies, message)
+		}
+		cursor = nextCursor
+		if !hasMore || cursor == "" {
+			return replies, nil
+		}
+	}
+}
+
+func (c *config) getLastActivity(channelID string) (time.Time, error) {
+	conversationHistory, err := c.userClient.GetConversationHistory(&slack.GetConversationHistoryParameters{ChannelID: channelID, Limit: 1})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(conversationHistory.Messages) == 0 {
+		return time.Time{}, nil
+	}
+	return tsToTime(conversationHistory.Messages[0].Msg.Timestamp), nil
+}
+
+func (c *config) findInactiveChannels(conversations []slack.Channel, now time.Time) []inactiveChannel {
+	since := now.AddDate(0, 0, -c.inactive.days)
+	channels := []inactiveChannel{}
+	for _, conversation := range conversations {
+		lastActivity, err := c.getLastActivity(conversation.ID)
+		if err != nil {
+			log.Println("can not get last activity channelID:", conversation.ID, err)
+			continue
+		}
+		if lastActivity.After(since) {
+			continue
+		}
+		channel := inactiveChannel{channelID: conversation.ID, name: conversation.Name, members: conversation.NumMembers, lastActivity: lastActivity}
+		info, err := c.userClient.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: conversation.ID, IncludeNumMembers: true})
+		if err != nil {
+			log.Println("can not get channel info channelID:", conversation.ID, err)
+		} else {
+			channel.name, channel.members, channel.checked = info.Name, info.NumMembers, true
+			if info.IsGeneral {
+				// the general channel can not be archived nor left
+				continue
+			}
+		}
+		channels = append(channels, channel)
+	}
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].lastActivity.Before(channels[j].lastActivity)
+	})
+	return channels
+}
+
+// reportChannelID, the channel the summary posts to, is never touched.
+func (c *config) applyInactivePolicy(channels []inactiveChannel, reportChannelID string) []inactiveChannel {
+	for i, channel := range channels {
+		if c.inactive.action == "" || channel.channelID == reportChannelID || !channel.checked {
+			continue
+		}
+		if c.inactive.maxMembers > 0 && channel.members > c.inactive.maxMembers {
+			continue
+		}
+		channels[i].action = c.inactive.action
+		if !c.inactive.apply {
+			continue
+		}
+		var err error
+		switch c.inactive.action {
+		case "archive":
+			err = c.userClient.ArchiveConversation(channel.channelID)
+		case "leave":
+			_, err = c.userClient.LeaveConversation(channel.channelID)
+		}
+		if err != nil {
+			log.Println("can not "+c.inactive.action+" channelID:", channel.channelID, err)
+			continue
+		}
+		channels[i].done = true
+	}
+	return channels
+}
+
+var pastTense = map[string]string{"archive": "archived", "leave": "left"}
+
+func (c *config) createInactiveMessage(channels []inactiveChannel) string {
+	if len(channels) == 0 {
+		return ""
+	}
+	text := "Inactive channels, no messages in " + strconv.Itoa(c.inactive.days) + " days"
+	if c.inactive.action != "" && !c.inactive.apply {
+		text += " (dry run)"
+	}
+	text += "\n"
+	for _, channel := range channels {
+		lastActivity := "never"
+		if !channel.lastActivity.IsZero() {
+			lastActivity = channel.lastActivity.In(c.period.from.Location()).Format("2006-01-02")
+		}
+		members := "members unknown"
+		if channel.checked {
+			members = strconv.Itoa(channel.members) + " members"
+		}
+		text += "<#" + channel.channelID + "> " + members + ", last activity " + lastActivity
+		if channel.done {
+			text += ", " + pastTense[channel.action]
+		} else if channel.action != "" {
+			text += ", would " + channel.action
+		}
+		text += "\n"
+	}
+	return text
+}
+
+// slackLinkPattern matches a link in Slack markup, <https://example.com|label> or <https://example.com>.
+var slackLinkPattern = regexp.MustCompile(`<(https?://[^|>\s]+)(?:\|([^>]*))?>`)
+
+var slackUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+type article struct {
+	link  string
+	title string
+	site  string
+}
+
+// extractArticles returns every URL in the text, the attachments and the blocks of message, each once.
+func extractArticles(message slack.Message) []article {
+	articles := []article{}
+	indexByLink := map[string]int{}
+	add := func(link, title string) {
+		if !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+			return
+		}
+		if i, ok := indexByLink[link]; ok {
+			if articles[i].title == "" {
+				articles[i].title = title
+			}
+			return
+		}
+		indexByLink[link] = len(articles)
+		articles = append(articles, article{link: link, title: title})
+	}
+	addText := func(text string) {
+		for _, match := range slackLinkPattern.FindAllStringSubmatch(text, -1) {
+			add(slackUnescaper.Replace(match[1]), slackUnescaper.Replace(match[2]))
+		}
+	}
+
+	addText(message.Msg.Text)
+	for _, attachment := range message.Msg.Attachments {
+		add(attachment.OriginalURL, attachment.Title)
+		add(attachment.FromURL, attachment.Title)
+		add(attachment.TitleLink, attachment.Title)
+		addText(attachment.Pretext)
+		addText(attachment.Text)
+	}
+	for _, block := range message.Msg.Blocks.BlockSet {
+		switch b := block.(type) {
+		case *slack.RichTextBlock:
+			for _, element := range b.Elements {
+				for _, link := range richTextLinks(element) {
+					add(link.URL, link.Text)
+				}
+			}
+		case *slack.SectionBlock:
+			if b.Text != nil {
+				addText(b.Text.Text)
+			}
+			for _, field := range b.Fields {
+				addText(field.Text)
+			}
+		}
+	}
+	return articles
+}
+
+func extractLinks(message slack.Message) []string {
+	links := []string{}
+	for _, a := range extractArticles(message) {
+		links = append(links, a.link)
+	}
+	return links
+}
+
+func richTextLinks(element slack.RichTextElement) []*slack.RichTextSectionLinkElement {
+	var elements []slack.RichTextSectionElement
+	switch e := element.(type) {
+	case *slack.RichTextSection:
+		elements = e.Elements
+	case *slack.RichTextQuote:
+		elements = e.Elements
+	case *slack.RichTextPreformatted:
+		elements = e.Elements
+	case *slack.RichTextList:
+		links := []*slack.RichTextSectionLinkElement{}
+		for _, child := range e.Elements {
+			links = append(links, richTextLinks(child)...)
+		}
+		return links
+	}
+	links := []*slack.RichTextSectionLinkElement{}
+	for _, element := range elements {
+		if link, ok := element.(*slack.RichTextSectionLinkElement); ok {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// trackingParams are query parameters that only tell where a click came from, utm_* are matched by prefix.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"yclid":   true,
+	"msclkid": true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+}
+
+// normalizeLink returns link with https scheme, lower case host without default port,
+// no tracking parameters, sorted query and no fragment. A link that can not be parsed is returned as is.
+func normalizeLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	u.Scheme = "https"
+	u.Host = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(u.Host), ":80"), ":443")
+	u.Fragment, u.RawFragment = "", ""
+	query := u.Query()
+	for k := range query {
+		if strings.HasPrefix(strings.ToLower(k), "utm_") || trackingParams[strings.ToLower(k)] {
+			query.Del(k)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+	if u.Path == "/" {
+		u.Path, u.RawPath = "", ""
+	}
+	return u.String()
+}
+
+func canonicalizeLink(link string, shorteners map[string]string) string {
+	canonical := normalizeLink(link)
+	// a shortener can point to another one, the number of shorteners bounds a loop
+	for range len(shorteners) {
+		target, ok := shorteners[canonical]
+		if !ok {
+			break
+		}
+		canonical = normalizeLink(target)
+	}
+	return canonical
+}
+
+func loadStringMap(path string) (map[string]string, error) {
+	m := map[string]string{}
+	if path == "" {
+		return m, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return map[string]string{}, err
+	}
+	return m, nil
+}
+
+func loadShorteners(path string) (map[string]string, error) {
+	targetByLink, err := loadStringMap(path)
+	shorteners := map[string]string{}
+	for link, target := range targetByLink {
+		shorteners[normalizeLink(link)] = target
+	}
+	return shorteners, err
+}
+
+// loadSiteAliases reads a JSON object from hosts or registrable domains to site names, e.g. {"example.com": "Example"}.
+func loadSiteAliases(path string) (map[string]string, error) {
+	siteByHost, err := loadStringMap(path)
+	aliases := map[string]string{}
+	for host, site := range siteByHost {
+		aliases[normalizeHost(host)] = site
+	}
+	return aliases, err
+}
+
+func hostOf(link string) string {
+	url, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return url.Host
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimPrefix(host, "www.")
+}
+
+// siteOf returns the site name of host. An alias of the host wins over an alias of its registrable domain,
+// and the registrable domain is used when subdomains are folded.
+func (c *config) siteOf(host string) string {
+	if host == "" {
+		return ""
+	}
+	host = normalizeHost(host)
+	if site, ok := c.siteByHost[host]; ok {
+		return site
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	if site, ok := c.siteByHost[domain]; ok {
+		return site
+	}
+	if c.foldSubdomains {
+		return domain
+	}
+	return host
+}
+
+// loadUserCache reads the user cache at path, the cache lives only in memory when path is empty.
+func loadUserCache(client *slack.Client, path string, ttl time.Duration) (*userCache, error) {
+	cache := &userCache{client: client, path: path, ttl: ttl, users: map[string]CachedUser{}}
+	if path == "" {
+		return cache, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+	if err := json.Unmarshal(b, &cache.users); err != nil {
+		cache.users = map[string]CachedUser{}
+		return cache, err
+	}
+	return cache, nil
+}
+
+func (cache *userCache) save() error {
+	if cache == nil || cache.path == "" || !cache.changed {
+		return nil
+	}
+	b, err := json.Marshal(cache.users)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cache.path, b, 0644)
+}
+
+func (cache *userCache) put(user slack.User) {
+	name := user.Profile.DisplayName
+	if name == "" {
+		name = user.RealName
+	}
+	if name == "" {
+		name = user.Name
+	}
+	cache.users[user.ID] = CachedUser{Name: name, Bot: user.IsBot, FetchedAt: time.Now()}
+	cache.changed = true
+}
+
+func (cache *userCache) fresh(id string) (CachedUser, bool) {
+	user, ok := cache.users[id]
+	return user, ok && time.Since(user.FetchedAt) < cache.ttl
+}
+
+// lookup returns the cached user of id. A stale or missing user is fetched with users.list once per run
+// and with users.info after that, a user that can not be fetched falls back to a stale entry.
+func (cache *userCache) lookup(id string) (CachedUser, bool) {
+	if cache == nil || id == "" {
+		return CachedUser{}, false
+	}
+	if user, ok := cache.fresh(id); ok {
+		return user, true
+	}
+	if !cache.listed {
+		cache.listed = true
+		users, err := cache.client.GetUsers()
+		if err != nil {
+			log.Println("can not get users:", err)
+		}
+		for _, user := range users {
+			cache.put(user)
+		}
+		if user, ok := cache.fresh(id); ok {
+			return user, true
+		}
+	}
+	user, err := cache.client.GetUserInfo(id)
+	if err != nil {
+		log.Println("can not get user userID:", id, err)
+		cached, ok := cache.users[id]
+		return cached, ok
+	}
+	cache.put(*user)
+	return cache.users[id], true
+}
+
+func userNameOf(message slack.Message) string {
+	if message.Msg.Username != "" {
+		return message.Msg.Username
+	} else if message.BotProfile != nil && message.BotProfile.Name != "" {
+		return message.BotProfile.Name
+	}
+	return ""
+}
+
+func (c *config) makeResult(conversations []slack.Channel) result {
+	latest := strconv.FormatInt(c.period.to.Unix(), 10)
+	oldest := strconv.FormatInt(c.period.from.Unix(), 10)
+
+	r := result{
+		countBySiteByChannel:  map[string]map[string]int{},
+		countByHumanByChannel: map[string]map[string]int{},
+		countByHostByChannel:  map[string]map[string]int{},
+		countByChannel:        map[string]int{},
+		countByDayByChannel:   map[string]map[string]int{},
+		countByHourByChannel:  map[string][]int{},
+		countByLinkByChannel:  map[string]map[string]int{},
+		channelsByLink:        map[string][]string{},
+		threads:               []thread{},
+
+		countByEmojiByChannel:        map[string]map[string]int{},
+		countReactionByHostByChannel: map[string]map[string]int{},
+		reacted:                      []reacted{},
+		articlesByChannel:            map[string][]article{},
+		mentions:                     []personalItem{},
+		questions:                    []personalItem{},
+		responsesByChannel:           map[string]*responses{},
+		trackers:                     c.searchQueries(conversations),
+	}
+
+	for _, conversation := range conversations {
+		messages, err := c.getConversationHistory(conversation.ID, latest, oldest)
+		if err != nil {
+			log.Println("can not get history channelID:", conversation.ID, err)
+			continue
+		}
+
+		i := 0
+		countByUser := map[string]int{}
+		countByHuman := map[string]int{}
+		countByHost := map[string]int{}
+		countByDay := map[string]int{}
+		countByHour := make([]int, 24)
+		countByLink := map[string]int{}
+		countByEmoji := map[string]int{}
+		countReactionByHost := map[string]int{}
+		articles := []article{}
+		indexByLink := map[string]int{}
+		count := func(message slack.Message) {
+			reactionCount := 0
+			for _, reaction := range message.Msg.Reactions {
+				countByEmoji[reaction.Name] += reaction.Count
+				reactionCount += reaction.Count
+			}
+			if reactionCount > 0 {
+				r.reacted = append(r.reacted, reacted{channelID: conversation.ID, ts: message.Msg.Timestamp, reactionCount: reactionCount})
+			}
+			hosts := map[string]bool{}
+			for _, a := range extractArticles(message) {
+				link := canonicalizeLink(a.link, c.shorteners)
+				host := c.siteOf(hostOf(link))
+				if host == "" {
+					continue
+				}
+				countByHost[host] += 1
+				countByLink[link] += 1
+				hosts[host] = true
+				if i, ok := indexByLink[link]; ok {
+					if articles[i].title == "" {
+						articles[i].title = a.title
+					}
+					continue
+				}
+				indexByLink[link] = len(articles)
+				articles = append(articles, article{link: link, title: a.title, site: host})
+			}
+			for host := range hosts {
+				if reactionCount > 0 {
+					countReactionByHost[host] += reactionCount
+				}
+			}
+			if c.owner != nil && message.Msg.User != c.owner.userID && c.owner.isMentioned(message.Msg.Text) {
+				r.mentions = append(r.mentions, personalItem{channelID: conversation.ID, ts: message.Msg.Timestamp, text: message.Msg.Text})
+			}
+			if userName := userNameOf(message); userName != "" {
+				countByUser[userName] += 1
+			} else if user, ok := c.users.lookup(message.Msg.User); ok {
+				if user.Bot {
+					countByUser[user.Name] += 1
+				} else if message.Msg.SubType == "" || message.Msg.SubType == "thread_broadcast" {
+					// the channel events of a member, e.g. channel_join, are not their activity
+					countByHuman[message.Msg.User] += 1
+				}
+			}
+		}
+		bucket := func(at time.Time, n int) {
+			at = at.In(c.period.from.Location())
+			countByDay[at.Format("2006-01-02")] += n
+			countByHour[at.Hour()] += n
+			i += n
+		}
+		for _, message := range messages {
+			postedAt := tsToTime(message.Msg.Timestamp)
+			bucket(postedAt, 1)
+			count(message)
+
+			replies := []slack.Message{}
+			var repliesErr error
+			timed := c.responseChannels[conversation.ID]
+			if (c.withReplies || timed) && message.ReplyCount > 0 {
+				repliesLatest := latest
+				if timed {
+					// the answers to a thread of the period may come after it
+					repliesLatest = ""
+				}
+				replies, repliesErr = c.getConversationReplies(conversation.ID, message.Msg.Timestamp, repliesLatest)
+				if repliesErr != nil {
+					log.Println("can not get replies channelID:", conversation.ID, "ts:", message.Msg.Timestamp, repliesErr)
+				}
+			}
+			if c.withReplies && message.ReplyCount > 0 && repliesErr == nil {
+				replyCount := 0
+				for _, reply := range replies {
+					repliedAt := tsToTime(reply.Msg.Timestamp)
+					if !repliedAt.Before(c.period.to) {
+						continue
+					}
+					bucket(repliedAt, 1)
+					count(reply)
+					replyCount += 1
+				}
+				r.threads = append(r.threads, thread{channelID: conversation.ID, ts: message.Msg.Timestamp, replyCount: replyCount})
+			} else {
+				// the replies that are not fetched are counted at the time of their parent
+				bucket(postedAt, message.ReplyCount)
+			}
+			// only the messages of members start a thread, not the bots nor the channel events
+			if timed && repliesErr == nil && message.Msg.SubType == "" {
+				if r.responsesByChannel[conversation.ID] == nil {
+					r.responsesByChannel[conversation.ID] = &responses{}
+				}
+				res := r.responsesByChannel[conversation.ID]
+				res.threads += 1
+				firstReply, resolved := c.threadResponse(message, replies)
+				if firstReply < 0 {
+					res.unanswered += 1
+				} else {
+					res.firstReply = append(res.firstReply, firstReply)
+				}
+				if resolved >= 0 {
+					res.resolved = append(res.resolved, resolved)
+				}
+			}
+		}
+
+		r.countByChannel[conversation.ID] = i
+		r.countBySiteByChannel[conversation.ID] = countByUser
+		r.countByHumanByChannel[conversation.ID] = countByHuman
+		r.countByHostByChannel[conversation.ID] = countByHost
+		r.countByDayByChannel[conversation.ID] = countByDay
+		r.countByHourByChannel[conversation.ID] = countByHour
+		r.countByLinkByChannel[conversation.ID] = countByLink
+		r.countByEmojiByChannel[conversation.ID] = countByEmoji
+		r.countReactionByHostByChannel[conversation.ID] = countReactionByHost
+		r.articlesByChannel[conversation.ID] = articles
+		for link := range countByLink {
+			r.channelsByLink[link] = append(r.channelsByLink[link], conversation.ID)
+		}
+	}
+
+	sort.SliceStable(r.threads, func(i, j int) bool {
+		return r.threads[i].replyCount > r.threads[j].replyCount
+	})
+	if len(r.threads) > MAX_THREADS {
+		r.threads = r.threads[:MAX_THREADS]
+	}
+	for i, t := range r.threads {
+		r.threads[i].permalink = c.getPermalink(t.channelID, t.ts)
+	}
+
+	sort.SliceStable(r.reacted, func(i, j int) bool {
+		return r.reacted[i].reactionCount > r.reacted[j].reactionCount
+	})
+	if len(r.reacted) > MAX_REACTED {
+		r.reacted = r.reacted[:MAX_REACTED]
+	}
+	for i, m := range r.reacted {
+		r.reacted[i].permalink = c.getPermalink(m.channelID, m.ts)
+	}
+
+	r.mentions = c.withPermalinks(r.mentions)
+	if c.owner != nil {
+		r.questions = c.withPermalinks(c.findQuestions(conversations))
+	}
+	return r
+}
+
+// threadResponse returns the time from message to the first reply by someone else than its author and to the resolution,
+// each is negative when it has not happened. The resolution is the first reply reacted with the resolved emoji,
+// or the latest reply when message itself is reacted with it.
+func (c *config) threadResponse(message slack.Message, replies []slack.Message) (time.Duration, time.Duration) {
+	startedAt := tsToTime(message.Msg.Timestamp)
+	firstReply, resolved := time.Duration(-1), time.Duration(-1)
+	for _, reply := range replies {
+		if firstReply < 0 && reply.Msg.User != message.Msg.User {
+			firstReply = tsToTime(reply.Msg.Timestamp).Sub(startedAt)
+		}
+		if resolved < 0 && hasReaction(reply, c.resolvedEmoji) {
+			resolved = tsToTime(reply.Msg.Timestamp).Sub(startedAt)
+		}
+	}
+	if resolved < 0 && len(replies) > 0 && hasReaction(message, c.resolvedEmoji) {
+		resolved = tsToTime(replies[len(replies)-1].Msg.Timestamp).Sub(startedAt)
+	}
+	return firstReply, resolved
+}
+
+func hasReaction(message slack.Message, emoji string) bool {
+	for _, reaction := range message.Msg.Reactions {
+		if reaction.Name == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// percentile returns the nearest-rank p-th percentile of durations, zero when there is none.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(durations))
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// windowQuery limits query to the days of the period with a day more on both sides, since the search modifiers after: and
+// before: exclude the given day and follow the timezone of the token user instead of SUMMARY_TZ.
+func (c *config) windowQuery(query string) string {
+	return query + " after:" + addDays(c.period.from, -2).Format("2006-01-02") + " before:" + addDays(c.period.to, 1).Format("2006-01-02")
+}
+
+// The search covers every conversation of the user token, so a match outside conversations, e.g. in a DM, is not counted.
+func (c *config) searchQueries(conversations []slack.Channel) []tracker {
+	reported := map[string]bool{}
+	for _, conversation := range conversations {
+		reported[conversation.ID] = true
+	}
+	trackers := []tracker{}
+	names := slices.Sorted(maps.Keys(c.queryByName))
+	for _, name := range names {
+		t := tracker{name: name, query: c.queryByName[name], matches: []personalItem{}}
+		if err := c.searchMatches(&t, reported); err != nil {
+			log.Println("can not search query:", name, err)
+			continue
+		}
+		trackers = append(trackers, t)
+	}
+	return trackers
+}
+
+func (c *config) searchMatches(t *tracker, reported map[string]bool) error {
+	params := slack.NewSearchParameters()
+	params.Count = SEARCH_COUNT
+	for {
+		res, err := c.userClient.SearchMessages(c.windowQuery(t.query), params)
+		if err != nil {
+			return err
+		}
+		for _, m := range res.Matches {
+			postedAt := tsToTime(m.Timestamp)
+			if !reported[m.Channel.ID] || postedAt.Before(c.period.from) || !postedAt.Before(c.period.to) {
+				continue
+			}
+			t.hits += 1
+			if len(t.matches) < MAX_TRACKER_MATCHES {
+				t.matches = append(t.matches, personalItem{channelID: m.Channel.ID, ts: m.Timestamp, text: m.Text, permalink: m.Permalink})
+			}
+		}
+		if res.Paging.Page >= res.Paging.Pages {
+			return nil
+		}
+		params.Page = res.Paging.Page + 1
+	}
+}
+
+func (c *config) withPermalinks(items []personalItem) []personalItem {
+	if len(items) > MAX_PERSONAL_ITEMS {
+		items = items[:MAX_PERSONAL_ITEMS]
+	}
+	for i, item := range items {
+		items[i].permalink = c.getPermalink(item.channelID, item.ts)
+	}
+	return items
+}
+
+func (c *config) getPermalink(channelID, ts string) string {
+	permalink, err := c.userClient.GetPermalink(&slack.PermalinkParameters{Channel: channelID, Ts: ts})
+	if err != nil {
+		log.Println("can not get permalink channelID:", channelID, "ts:", ts, err)
+		return ""
+	}
+	return permalink
+}
+
+func resolveOwner(client *slack.Client, questionAge time.Duration, questionChannels map[string]bool, now time.Time) (*owner, error) {
+	res, err := client.AuthTest()
+	if err != nil {
+		return nil, err
+	}
+	o := &owner{userID: res.UserID, groupIDs: []string{}, questionAge: questionAge, questionChannels: questionChannels, now: now}
+	groups, err := client.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+	if err != nil {
+		// user groups need the usergroups:read scope, the mentions of the user are found without them
+		log.Println("can not get user groups:", err)
+		return o, nil
+	}
+	for _, group := range groups {
+		if slices.Contains(group.Users, o.userID) {
+			o.groupIDs = append(o.groupIDs, group.ID)
+		}
+	}
+	return o, nil
+}
+
+// isMentioned reports whether text mentions the owner, as <@U123> or <@U123|name>, or one of their user groups, as <!subteam^S123>.
+func (o *owner) isMentioned(text string) bool {
+	tokens := []string{"<@" + o.userID}
+	for _, id := range o.groupIDs {
+		tokens = append(tokens, "<!subteam^"+id)
+	}
+	for _, token := range tokens {
+		for rest := text; ; {
+			i := strings.Index(rest, token)
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len(token):]
+			if strings.HasPrefix(rest, ">") || strings.HasPrefix(rest, "|") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isQuestion(text string) bool {
+	text = strings.TrimSpace(text)
+	return strings.HasSuffix(text, "?") || strings.HasSuffix(text, "？")
+}
+
+// findQuestions judges the questions whose questionAge ran out in the last period length, so that a run after every period
+// judges every question once, even one posted after the time of day of the run.
+func (c *config) findQuestions(conversations []slack.Channel) []personalItem {
+	latest := c.owner.now.Add(-c.owner.questionAge)
+	oldest := latest.Add(-c.period.to.Sub(c.period.from))
+	questions := []personalItem{}
+	for _, conversation := range conversations {
+		if len(c.owner.questionChannels) > 0 && !c.owner.questionChannels[conversation.ID] {
+			continue
+		}
+		messages, err := c.getConversationHistory(conversation.ID, strconv.FormatInt(latest.Unix(), 10), strconv.FormatInt(oldest.Unix(), 10))
+		if err != nil {
+			log.Println("can not get history channelID:", conversation.ID, err)
+			continue
+		}
+		for _, message := range messages {
+			if !isQuestion(message.Msg.Text) {
+				continue
+			}
+			replies := []slack.Message{}
+			if message.ReplyCount > 0 {
+				replies, err = c.getConversationReplies(conversation.ID, message.Msg.Timestamp, "")
+				if err != nil {
+					log.Println("can not get replies channelID:", conversation.ID, "ts:", message.Msg.Timestamp, err)
+					continue
+				}
+			}
+			if c.owner.isUnanswered(message, replies) {
+				questions = append(questions, personalItem{channelID: conversation.ID, ts: message.Msg.Timestamp, text: message.Msg.Text})
+			}
+		}
+	}
+	return questions
+}
+
+// A question younger than questionAge is not judged yet. Without the replies, any reply answers the question.
+func (o *owner) isUnanswered(message slack.Message, replies []slack.Message) bool {
+	if !isQuestion(message.Msg.Text) || len(message.Msg.Reactions) > 0 {
+		return false
+	}
+	deadline := tsToTime(message.Msg.Timestamp).Add(o.questionAge)
+	if o.now.Before(deadline) {
+		return false
+	}
+	if message.ReplyCount == 0 {
+		return true
+	}
+	if len(replies) == 0 {
+		return false
+	}
+	for _, reply := range replies {
+		// a reply of the asker, e.g. "bump", does not answer
+		if reply.Msg.User != message.Msg.User {
+			return !tsToTime(reply.Msg.Timestamp).Before(deadline)
+		}
+	}
+	return true
+}
+
+// snippet returns the first line of text cut to SNIPPET_LENGTH runes, without a mention or a link cut in the middle.
+func snippet(text string) string {
+	text, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	runes := []rune(text)
+	if len(runes) <= SNIPPET_LENGTH {
+		return text
+	}
+	text = string(runes[:SNIPPET_LENGTH])
+	if i := strings.LastIndex(text, "<"); i > strings.LastIndex(text, ">") {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text) + "…"
+}
+
+func personalLine(item personalItem) string {
+	line := "• <#" + item.channelID + "> " + snippet(item.text)
+	if item.permalink != "" {
+		line += " <" + item.permalink + "|open>"
+	}
+	return line + "\n"
+}
+
+func createPersonalMessage(r result) string {
+	text := ""
+	if len(r.mentions) > 0 {
+		text += "*Mentions*\n"
+		for _, item := range r.mentions {
+			text += personalLine(item)
+		}
+	}
+	if len(r.questions) > 0 {
+		text += "*Unanswered questions*\n"
+		for _, item := range r.questions {
+			text += personalLine(item)
+		}
+	}
+	return text
+}
+
+func postPersonalMessage(botClient *slack.Client, userID, text string) error {
+	channel, _, _, err := botClient.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}})
+	if err != nil {
+		return err
+	}
+	_, _, err = botClient.PostMessage(channel.ID, slack.MsgOptionText(text, false))
+	return err
+}
+
+// The recent days are the stallDays days up to the period, all of them have to be recorded.
+func (c *config) feedHealth(r result) []feed {
+	if c.period.name != "day" || c.stallDays < 1 {
+		return nil
+	}
+	countByAuthorByChannelOf := func(day time.Time) (map[string]map[string]int, bool) {
+		if !day.Before(c.period.from) {
+			return r.countBySiteByChannel, true
+		}
+		record, ok := c.history[day.Format("2006-01-02")]
+		return record.CountByAuthorByChannel, ok
+	}
+
+	recent := []map[string]map[string]int{}
+	for i := c.stallDays - 1; i >= 0; i-- {
+		countByAuthorByChannel, ok := countByAuthorByChannelOf(addDays(c.period.from, -i))
+		if !ok {
+			return nil
+		}
+		recent = append(recent, countByAuthorByChannel)
+	}
+
+	sumByFeed := map[[2]string]int{}
+	recordedDays := 0
+	for i := c.stallDays; i < c.stallDays+BASELINE_DAYS; i++ {
+		countByAuthorByChannel, ok := countByAuthorByChannelOf(addDays(c.period.from, -i))
+		if !ok {
+			continue
+		}
+		recordedDays += 1
+		for channelID, countByAuthor := range countByAuthorByChannel {
+			for author, count := range countByAuthor {
+				sumByFeed[[2]string{channelID, author}] += count
+			}
+		}
+	}
+	if recordedDays < MIN_BASELINE_DAYS {
+		return nil
+	}
+
+	feeds := []feed{}
+	for key, sum := range sumByFeed {
+		f := feed{channelID: key[0], author: key[1], baseline: float64(sum) / float64(recordedDays), recent: []int{}}
+		// a feed expected to post less than MIN_STALL_EXPECTED messages in the recent days is quiet, not stalled
+		f.stalled = f.baseline*float64(c.stallDays) >= MIN_STALL_EXPECTED
+		for _, countByAuthorByChannel := range recent {
+			count := countByAuthorByChannel[f.channelID][f.author]
+			f.recent = append(f.recent, count)
+			if float64(count) >= f.baseline*c.stallRatio && count > 0 {
+				f.stalled = false
+			}
+		}
+		feeds = append(feeds, f)
+	}
+	sort.Slice(feeds, func(i, j int) bool {
+		if feeds[i].channelID != feeds[j].channelID {
+			return feeds[i].channelID < feeds[j].channelID
+		}
+		return feeds[i].author < feeds[j].author
+	})
+	return feeds
+}
+
+func createStalledMessage(feeds []feed) string {
+	text := ""
+	for _, f := range feeds {
+		if f.stalled {
+			text += fmt.Sprintf("<#%s> %s : %s (baseline %.1f/day)\n", f.channelID, f.author, joinInts(f.recent, " / "), f.baseline)
+		}
+	}
+	if text == "" {
+		return ""
+	}
+	return "Stalled feeds\n" + text
+}
+
+const MAX_DIGEST_ARTICLES = 10
+
+// Only the first MAX_DIGEST_ARTICLES articles are listed, the rest is counted in "and N more".
+func createDigest(channelID string, articles []article) string {
+	if len(articles) == 0 {
+		return ""
+	}
+	countBySite := map[string]int{}
+	articlesBySite := map[string][]article{}
+	for _, a := range articles {
+		countBySite[a.site] += 1
+		articlesBySite[a.site] = append(articlesBySite[a.site], a)
+	}
+	text := "*Articles in <#" + channelID + ">*\n"
+	listed := 0
+	for _, site := range sortByCount(countBySite) {
+		if listed >= MAX_DIGEST_ARTICLES {
+			break
+		}
+		text += "*" + site + "*\n"
+		for _, a := range articlesBySite[site] {
+			if listed >= MAX_DIGEST_ARTICLES {
+				break
+			}
+			if a.title == "" {
+				text += "• <" + a.link + ">\n"
+			} else {
+				text += "• <" + a.link + "|" + slackEscaper.Replace(strings.ReplaceAll(a.title, "|", "¦")) + ">\n"
+			}
+			listed += 1
+		}
+	}
+	if more := len(articles) - listed; more > 0 {
+		text += "and " + strconv.Itoa(more) + " more\n"
+	}
+	return text
+}
+
+func postDigests(botClient *slack.Client, channelID, ts string, r result, channelById map[string]slack.Channel) {
+	for _, id := range channelIDs(r, channelById) {
+		digest := createDigest(id, r.articlesByChannel[id])
+		if digest == "" {
+			continue
+		}
+		if _, _, err := botClient.PostMessage(channelID, slack.MsgOptionText(digest, false), slack.MsgOptionTS(ts)); err != nil {
+			log.Println("can not post digest channelID:", id, err)
+		}
+	}
+}
+
+// uniqueCountByHostByChannel counts every link once, in the first channel it was summarized in.
+func (c *config) uniqueCountByHostByChannel(r result) map[string]map[string]int {
+	countByHostByChannel := map[string]map[string]int{}
+	for channelID, countByHost := range r.countByHostByChannel {
+		unique := map[string]int{}
+		for host, count := range countByHost {
+			unique[host] = count
+		}
+		for link, count := range r.countByLinkByChannel[channelID] {
+			keep := 0
+			if r.channelsByLink[link][0] == channelID {
+				keep = 1
+			}
+			site := c.siteOf(hostOf(link))
+			unique[site] -= count - keep
+			if unique[site] <= 0 {
+				delete(unique, site)
+			}
+		}
+		countByHostByChannel[channelID] = unique
+	}
+	return countByHostByChannel
+}
+
+func duplicates(r result) []Duplicate {
+	duplicates := []Duplicate{}
+	for link, channels := range r.channelsByLink {
+		if len(channels) > 1 {
+			duplicates = append(duplicates, Duplicate{Link: link, Channels: channels})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if len(duplicates[i].Channels) != len(duplicates[j].Channels) {
+			return len(duplicates[i].Channels) > len(duplicates[j].Channels)
+		}
+		return duplicates[i].Link < duplicates[j].Link
+	})
+	if len(duplicates) > MAX_DUPLICATES {
+		duplicates = duplicates[:MAX_DUPLICATES]
+	}
+	return duplicates
+}
+
+// sortByCount returns the keys of countByKey, the largest count first and ties by key.
+func sortByCount(countByKey map[string]int) []string {
+	keys := make([]string, 0, len(countByKey))
+	for k := range countByKey {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if countByKey[keys[i]] != countByKey[keys[j]] {
+			return countByKey[keys[i]] > countByKey[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func anonymizedNames(countByHuman map[string]int) map[string]string {
+	nameByHuman := map[string]string{}
+	for i, human := range sortByCount(countByHuman) {
+		nameByHuman[human] = "user " + strconv.Itoa(i+1)
+	}
+	return nameByHuman
+}
+
+func named(counts []Count, nameByKey map[string]string) []Count {
+	for i, count := range counts {
+		counts[i].Name = nameByKey[count.Name]
+	}
+	return counts
+}
+
+// The humans who share a name stay apart because they are counted by ID.
+func (c *config) humanNames(countByHuman map[string]int) map[string]string {
+	if c.anonymize {
+		return anonymizedNames(countByHuman)
+	}
+	nameByHuman := map[string]string{}
+	for id := range countByHuman {
+		nameByHuman[id] = id
+		if user, ok := c.users.lookup(id); ok && user.Name != "" {
+			nameByHuman[id] = user.Name
+		}
+	}
+	return nameByHuman
+}
+
+// channelIDs returns the channels that have authors to report, the busiest first.
+func channelIDs(r result, channelById map[string]slack.Channel) []string {
+	countByChannel := map[string]int{}
+	for id := range channelById {
+		if len(r.countBySiteByChannel[id]) == 0 && len(r.countByHumanByChannel[id]) == 0 {
+			continue
+		}
+		countByChannel[id] = r.countByChannel[id]
+	}
+	return sortByCount(countByChannel)
+}
+
+func (c *config) title() []string {
+	last := addDays(c.period.to, -1)
+	switch c.period.name {
+	case "week":
+		year, week := c.period.from.ISOWeek()
+		return []string{fmt.Sprintf("%d-W%02d", year, week), c.period.from.Format("2006-01-02") + " - " + last.Format("2006-01-02")}
+	case "month":
+		return []string{c.period.from.Format("2006-01"), c.period.from.Format("January")}
+	case "custom":
+		return []string{c.period.from.Format("2006-01-02") + " - " + last.Format("2006-01-02")}
+	}
+	return []string{c.period.from.Format("2006-01-02"), c.period.from.Format("Monday")}
+}
+
+const BAR_WIDTH = 10
+
+func bar(count, max int) string {
+	filled := BAR_WIDTH
+	if max > 0 {
+		filled = (count*BAR_WIDTH + max - 1) / max
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", BAR_WIDTH-filled)
+}
+
+func total(countByChannel map[string]int) int {
+	count := 0
+	for _, v := range countByChannel {
+		count += v
+	}
+	return count
+}
+
+func counts(countByKey, dayBefore map[string]int, dayOk bool, weekBefore map[string]int, weekOk bool) []Count {
+	counts := []Count{}
+	for _, k := range sortByCount(countByKey) {
+		counts = append(counts, Count{
+			Name:         k,
+			Count:        countByKey[k],
+			DayOverDay:   makeTrend(countByKey[k], dayBefore[k], dayOk),
+			WeekOverWeek: makeTrend(countByKey[k], weekBefore[k], weekOk),
+		})
+	}
+	return counts
+}
+
+func sum(countByKeyByChannel map[string]map[string]int) map[string]int {
+	countByKey := map[string]int{}
+	for _, m := range countByKeyByChannel {
+		for k, v := range m {
+			countByKey[k] += v
+		}
+	}
+	return countByKey
+}
+
+func (c *config) makeReport(r result, channelById map[string]slack.Channel) Report {
+	days := c.period.days()
+	dayBefore, dayOk, weekBefore, weekOk := c.previousRecords()
+	report := Report{
+		Period:       ReportPeriod{Name: c.period.name, From: c.period.from, To: c.period.to, Title: c.title(), Days: days},
+		Total:        total(r.countByChannel),
+		DayOverDay:   makeTrend(total(r.countByChannel), total(dayBefore.CountByChannel), dayOk),
+		WeekOverWeek: makeTrend(total(r.countByChannel), total(weekBefore.CountByChannel), weekOk),
+		Channels:     []ChannelReport{},
+		Hourly:       make([]int, 24),
+		Threads:      []ThreadReport{},
+		Duplicates:   duplicates(r),
+		Reacted:      []ReactedReport{},
+	}
+	nameByHuman := c.humanNames(sum(r.countByHumanByChannel))
+	countByAuthor, countByHuman, countByHost, countByLink := map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}
+	for _, id := range channelIDs(r, channelById) {
+		daily := []int{}
+		for _, day := range days {
+			daily = append(daily, r.countByDayByChannel[id][day.Format("2006-01-02")])
+		}
+		for k, v := range r.countBySiteByChannel[id] {
+			countByAuthor[k] += v
+		}
+		for k, v := range r.countByHumanByChannel[id] {
+			countByHuman[k] += v
+		}
+		for k, v := range r.countByHostByChannel[id] {
+			countByHost[k] += v
+		}
+		for k, v := range r.countByLinkByChannel[id] {
+			countByLink[k] += v
+		}
+		channel := ChannelReport{
+			ID:           id,
+			Name:         channelById[id].Name,
+			Total:        r.countByChannel[id],
+			DayOverDay:   makeTrend(r.countByChannel[id], dayBefore.CountByChannel[id], dayOk),
+			WeekOverWeek: makeTrend(r.countByChannel[id], weekBefore.CountByChannel[id], weekOk),
+			Daily:        daily,
+			Hourly:       hourly(r.countByHourByChannel[id]),
+			Authors:      counts(r.countBySiteByChannel[id], dayBefore.CountByAuthorByChannel[id], dayOk, weekBefore.CountByAuthorByChannel[id], weekOk),
+			Humans:       named(counts(r.countByHumanByChannel[id], nil, false, nil, false), nameByHuman),
+			Hosts:        counts(r.countByHostByChannel[id], dayBefore.CountByHostByChannel[id], dayOk, weekBefore.CountByHostByChannel[id], weekOk),
+			Links:        counts(r.countByLinkByChannel[id], nil, false, nil, false),
+			Reactions:    total(r.countByEmojiByChannel[id]),
+			Emoji:        counts(r.countByEmojiByChannel[id], nil, false, nil, false),
+		}
+		if res, ok := r.responsesByChannel[id]; ok {
+			channel.Response = &ResponseReport{
+				Threads:          res.threads,
+				Unanswered:       res.unanswered,
+				FirstReplyMedian: percentile(res.firstReply, 0.5),
+				FirstReplyP90:    percentile(res.firstReply, 0.9),
+				Resolved:         len(res.resolved),
+				ResolvedMedian:   percentile(res.resolved, 0.5),
+				ResolvedP90:      percentile(res.resolved, 0.9),
+			}
+		}
+		for hour, count := range channel.Hourly {
+			report.Hourly[hour] += count
+		}
+		channel.Flagged = isUnusual(channel.DayOverDay, c.trendThreshold) || isUnusual(channel.WeekOverWeek, c.trendThreshold)
+		report.Channels = append(report.Channels, channel)
+	}
+	report.Authors = counts(countByAuthor, sum(dayBefore.CountByAuthorByChannel), dayOk, sum(weekBefore.CountByAuthorByChannel), weekOk)
+	report.Humans = named(counts(countByHuman, nil, false, nil, false), nameByHuman)
+	report.Hosts = counts(countByHost, sum(dayBefore.CountByHostByChannel), dayOk, sum(weekBefore.CountByHostByChannel), weekOk)
+	report.Links = counts(countByLink, nil, false, nil, false)
+	for _, t := range r.threads {
+		report.Threads = append(report.Threads, ThreadReport{ChannelID: t.channelID, ReplyCount: t.replyCount, Permalink: t.permalink})
+	}
+	for _, m := range r.reacted {
+		report.Reacted = append(report.Reacted, ReactedReport{ChannelID: m.channelID, Reactions: m.reactionCount, Permalink: m.permalink})
+	}
+	report.Emoji = counts(sum(r.countByEmojiByChannel), nil, false, nil, false)
+	if len(report.Emoji) > MAX_EMOJI {
+		report.Emoji = report.Emoji[:MAX_EMOJI]
+	}
+	report.Trackers = []TrackerReport{}
+	for _, t := range r.trackers {
+		matches := []MatchReport{}
+		for _, m := range t.matches {
+			matches = append(matches, MatchReport{ChannelID: m.channelID, Text: m.text, Permalink: m.permalink})
+		}
+		report.Trackers = append(report.Trackers, TrackerReport{Name: t.name, Query: t.query, Hits: t.hits, Matches: matches})
+	}
+	return report
+}
+
+func hourly(countByHour []int) []int {
+	counts := make([]int, 24)
+	copy(counts, countByHour)
+	return counts
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+func sparkline(counts []int) string {
+	max := 0
+	for _, count := range counts {
+		if count > max {
+			max = count
+		}
+	}
+	line := []rune{}
+	for _, count := range counts {
+		if count == 0 {
+			line = append(line, ' ')
+			continue
+		}
+		line = append(line, sparks[(count*len(sparks)+max-1)/max-1])
+	}
+	return string(line)
+}
+
+// formatDuration renders d in its two largest units, e.g. "1d2h", "3h5m", "12m" or "40s".
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", d/time.Hour, d%time.Hour/time.Minute)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+func responseLine(response *ResponseReport) string {
+	if response == nil || response.Threads == 0 {
+		return ""
+	}
+	parts := []string{}
+	if response.Threads > response.Unanswered {
+		parts = append(parts, "first reply "+formatDuration(response.FirstReplyMedian)+" / p90 "+formatDuration(response.FirstReplyP90))
+	}
+	if response.Resolved > 0 {
+		parts = append(parts, "resolved "+formatDuration(response.ResolvedMedian)+" / p90 "+formatDuration(response.ResolvedP90))
+	}
+	parts = append(parts, "unanswered "+strconv.Itoa(response.Unanswered)+"/"+strconv.Itoa(response.Threads))
+	return strings.Join(parts, ", ")
+}
+
+func hours(countByHour []int) string {
+	peak := 0
+	for hour, count := range countByHour {
+		if count > countByHour[peak] {
+			peak = hour
+		}
+	}
+	if len(countByHour) == 0 || countByHour[peak] == 0 {
+		return ""
+	}
+	return fmt.Sprintf("`%s` peak %02d:00", sparkline(countByHour), peak)
+}
+
+func joinInts(values []int, sep string) string {
+	s := []string{}
+	for _, v := range values {
+		s = append(s, strconv.Itoa(v))
+	}
+	return strings.Join(s, sep)
+}
+
+var templateFuncs = template.FuncMap{
+	"join":      joinInts,
+	"sparkline": sparkline,
+	"hours":     hours,
+	"response":  responseLine,
+	"snippet":   snippet,
+	"cell":      markdownCell,
+	"bar":       bar,
+	"trend":     formatTrend,
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func renderTemplate(text string, report Report) (string, error) {
+	tmpl, err := template.New("summary").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, report); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderTemplateFile renders the template at path. A template ending in .json renders Block Kit blocks,
+// any other template renders the text of the message.
+func renderTemplateFile(path string, report Report) (string, []slack.Block, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	rendered, err := renderTemplate(string(text), report)
+	if err != nil {
+		return "", nil, err
+	}
+	if !strings.HasSuffix(path, ".json") {
+		return rendered, nil, nil
+	}
+	var blocks slack.Blocks
+	if err := json.Unmarshal([]byte(rendered), &blocks); err != nil {
+		return "", nil, fmt.Errorf("can not decode blocks: %w", err)
+	}
+	return "", blocks.BlockSet, nil
+}
+
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(text)
+}
+
+// expandPath replaces {date} in path with the first day of the report, e.g. "summary/{date}.md".
+func expandPath(path string, report Report) string {
+	return strings.ReplaceAll(path, "{date}", report.Period.From.Format("2006-01-02"))
+}
+
+func writeMarkdown(path string, report Report) error {
+	text, err := renderTemplate(MARKDOWN_TEMPLATE, report)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(expandPath(path, report), []byte(text), 0644)
+}
+
+func writeJSON(path string, report Report) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(expandPath(path, report), b, 0644)
+}
+
+func postWebhook(webhookURL string, report Report) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: WEBHOOK_TIMEOUT}
+	res, err := client.Post(webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
 
-	c := &config{userClient: userClient, now: now, yesterDay: yesterDay}
-	conversations := c.getConversationsForUser()
+func writeOutputs(report Report, markdownPath, jsonPath, webhookURL string) {
+	if markdownPath != "" {
+		if err := writeMarkdown(markdownPath, report); err != nil {
+			log.Println("can not write markdown:", err)
+		}
+	}
+	if jsonPath != "" {
+		if err := writeJSON(jsonPath, report); err != nil {
+			log.Println("can not write json:", err)
+		}
+	}
+	if webhookURL != "" {
+		if err := postWebhook(webhookURL, report); err != nil {
+			log.Println("can not post webhook:", err)
+		}
+	}
+}
 
-	channelById := map[string]slack.Channel{}
-	for _, channel := range conversations {
-		channelById[channel.ID] = channel
+func createMessage(report Report) string {
+	message, err := renderTemplate(DEFAULT_TEMPLATE, report)
+	if err != nil {
+		log.Println("can not render default template:", err)
 	}
+	return message
+}
 
-	countBySiteByChannel, countByHostByChannel, countBychannel := c.makeResult(conversations)
+func trends(dayOverDay, weekOverWeek Trend) string {
+	text := ""
+	if t := formatTrend(dayOverDay); t != "" {
+		text += " d/d " + t
+	}
+	if t := formatTrend(weekOverWeek); t != "" {
+		text += " w/w " + t
+	}
+	return text
+}
 
-	message := c.createMessage(countBySiteByChannel, countBychannel, channelById)
-	botClient := slack.New(os.Getenv("SLACK_BOT_TOKEN"))
-	_, _, err := botClient.PostMessage(os.Getenv("SLACK_CHANNEL_ID"), slack.MsgOptionText(message, false))
+const (
+	MAX_BLOCKS         = 50
+	MAX_SECTION_LENGTH = 3000
+	MAX_MESSAGE_LENGTH = 40000
+	// MAX_REPLY_LENGTH keeps a thread reply short enough to be shown without "Show more".
+	MAX_REPLY_LENGTH = 4000
+)
+
+func markdownSection(text string) slack.Block {
+	return slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)
+}
+
+func markdownSections(text string) []slack.Block {
+	blocks := []slack.Block{}
+	for _, chunk := range splitLines(text, MAX_SECTION_LENGTH) {
+		blocks = append(blocks, markdownSection(chunk))
+	}
+	return blocks
+}
+
+// A line longer than limit is cut at rune boundaries.
+func splitLines(text string, limit int) []string {
+	if limit < 1 || len(text) <= limit {
+		return []string{text}
+	}
+	chunks := []string{}
+	chunk := ""
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for len(line) > limit {
+			if chunk != "" {
+				chunks = append(chunks, chunk)
+				chunk = ""
+			}
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(line)
+			}
+			chunks = append(chunks, line[:cut])
+			line = line[cut:]
+		}
+		if len(chunk)+len(line) > limit {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+		chunk += line
+	}
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func headerBlock(report Report) slack.Block {
+	header := strings.Join(report.Period.Title, " ") + " : " + strconv.FormatInt(int64(report.Total), 10) + trends(report.DayOverDay, report.WeekOverWeek)
+	return slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, header, true, false))
+}
+
+func footerBlock(report Report) slack.Block {
+	footer := "manage-slack/summary " + report.Period.From.Format("2006-01-02 15:04") + " - " + report.Period.To.Format("2006-01-02 15:04 MST")
+	return slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, footer, false, false))
+}
+
+func channelText(channel ChannelReport, days int) string {
+	text := "*<#" + channel.ID + ">* " + strconv.FormatInt(int64(channel.Total), 10) + trends(channel.DayOverDay, channel.WeekOverWeek)
+	if channel.Flagged {
+		text += " :warning:"
+	}
+	text += "\n"
+	if days > 1 {
+		text += "daily : " + joinInts(channel.Daily, " / ") + "\n"
+	}
+	if h := hours(channel.Hourly); h != "" {
+		text += "hours : " + h + "\n"
+	}
+	for _, author := range channel.Authors {
+		text += "`" + bar(author.Count, channel.Authors[0].Count) + "` " + author.Name + " : " + strconv.FormatInt(int64(author.Count), 10) + trends(author.DayOverDay, author.WeekOverWeek) + "\n"
+	}
+	if channel.Reactions > 0 {
+		text += "reactions : " + strconv.Itoa(channel.Reactions) + "\n"
+	}
+	if line := responseLine(channel.Response); line != "" {
+		text += "response : " + line + "\n"
+	}
+	if len(channel.Humans) > 0 {
+		humans := []string{}
+		for _, human := range channel.Humans {
+			humans = append(humans, human.Name+" "+strconv.Itoa(human.Count))
+		}
+		text += "humans : " + strings.Join(humans, " / ") + "\n"
+	}
+	if len(channel.Hosts) > 0 {
+		sites := []string{}
+		for _, host := range channel.Hosts {
+			sites = append(sites, host.Name+" "+strconv.Itoa(host.Count))
+		}
+		text += "sites : " + strings.Join(sites, " / ") + "\n"
+	}
+	return text
+}
+
+func extraBlocks(report Report) []slack.Block {
+	blocks := []slack.Block{}
+	if len(report.Threads) > 0 {
+		text := "*Most discussed threads*\n"
+		for _, t := range report.Threads {
+			replies := strconv.FormatInt(int64(t.ReplyCount), 10) + " replies"
+			if t.Permalink != "" {
+				replies = "<" + t.Permalink + "|" + replies + ">"
+			}
+			text += "<#" + t.ChannelID + "> : " + replies + "\n"
+		}
+		blocks = append(blocks, slack.NewDividerBlock(), markdownSection(text))
+	}
+	if len(report.Duplicates) > 0 {
+		text := "*Duplicate articles*\n"
+		for _, d := range report.Duplicates {
+			channels := []string{}
+			for _, id := range d.Channels {
+				channels = append(channels, "<#"+id+">")
+			}
+			text += d.Link + " : posted in " + strconv.Itoa(len(d.Channels)) + " channels " + strings.Join(channels, " ") + "\n"
+		}
+		blocks = append(blocks, slack.NewDividerBlock(), markdownSection(text))
+	}
+	if len(report.Reacted) > 0 {
+		text := "*Most reacted messages*\n"
+		for _, m := range report.Reacted {
+			reactions := strconv.Itoa(m.Reactions) + " reactions"
+			if m.Permalink != "" {
+				reactions = "<" + m.Permalink + "|" + reactions + ">"
+			}
+			text += "<#" + m.ChannelID + "> : " + reactions + "\n"
+		}
+		blocks = append(blocks, slack.NewDividerBlock(), markdownSection(text))
+	}
+	if len(report.Trackers) > 0 {
+		text := "*Keyword trackers*\n"
+		for _, t := range report.Trackers {
+			text += "*" + t.Name + "* : " + strconv.Itoa(t.Hits) + " hits\n"
+			for _, m := range t.Matches {
+				text += personalLine(personalItem{channelID: m.ChannelID, text: m.Text, permalink: m.Permalink})
+			}
+		}
+		blocks = append(blocks, slack.NewDividerBlock(), markdownSection(text))
+	}
+	if len(report.Emoji) > 0 {
+		emoji := []string{}
+		for _, e := range report.Emoji {
+			emoji = append(emoji, ":"+e.Name+": "+strconv.Itoa(e.Count))
+		}
+		text := "*Top emoji*\n" + strings.Join(emoji, " / ") + "\n"
+		blocks = append(blocks, slack.NewDividerBlock(), markdownSection(text))
+	}
+	return blocks
+}
+
+func createBlocks(report Report) []slack.Block {
+	blocks := []slack.Block{headerBlock(report)}
+	for _, channel := range report.Channels {
+		blocks = append(blocks, slack.NewDividerBlock())
+		blocks = append(blocks, markdownSections(channelText(channel, len(report.Period.Days)))...)
+	}
+	blocks = append(blocks, extraBlocks(report)...)
+	return append(blocks, footerBlock(report))
+}
+
+// createOverviewBlocks is createBlocks without the channels, which are replied in the thread by createChannelReplies.
+func createOverviewBlocks(report Report) []slack.Block {
+	blocks := []slack.Block{headerBlock(report)}
+	if len(report.Channels) > 0 {
+		blocks = append(blocks, markdownSection(strconv.Itoa(len(report.Channels))+" channels, the details are in the thread"))
+	}
+	blocks = append(blocks, extraBlocks(report)...)
+	return append(blocks, footerBlock(report))
+}
+
+func createChannelReplies(report Report, perReply int) []string {
+	perReply = max(perReply, 1)
+	replies := []string{}
+	for i := 0; i < len(report.Channels); i += perReply {
+		texts := []string{}
+		for _, channel := range report.Channels[i:min(i+perReply, len(report.Channels))] {
+			texts = append(texts, channelText(channel, len(report.Period.Days)))
+		}
+		replies = append(replies, splitLines(strings.Join(texts, "\n"), MAX_REPLY_LENGTH)...)
+	}
+	return replies
+}
+
+func isMsgTooLong(err error) bool {
+	var slackErr slack.SlackErrorResponse
+	return errors.As(err, &slackErr) && slackErr.Err == "msg_too_long"
+}
+
+// A report over the limits of a message, or any report when split is true, is posted as the overview with the channels
+// replied in its thread. The output of a custom template is split by size instead, see postTemplated.
+func postReport(botClient *slack.Client, channelID, message string, blocks []slack.Block, report Report, split bool, perReply int, templated bool) (string, error) {
+	if templated {
+		return postTemplated(botClient, channelID, message, blocks, report, split)
+	}
+	if !split && len(blocks) <= MAX_BLOCKS && len(message) <= MAX_MESSAGE_LENGTH {
+		options := []slack.MsgOption{slack.MsgOptionText(message, false)}
+		if len(blocks) > 0 {
+			options = append(options, slack.MsgOptionBlocks(blocks...))
+		}
+		_, ts, err := botClient.PostMessage(channelID, options...)
+		if !isMsgTooLong(err) {
+			return ts, err
+		}
+		log.Println("the report is too long, split it:", err)
+	}
+	overview := createOverviewBlocks(report)
+	ts, err := postChunked(botClient, channelID, "", fallbackText(report), overview)
 	if err != nil {
-		log.Println("can not post:", err)
+		return "", err
 	}
-	sendMetrics(countByHostByChannel, channelById)
+	for _, reply := range createChannelReplies(report, perReply) {
+		postReply(botClient, channelID, ts, reply)
+	}
+	return ts, nil
 }
 
-func (c *config) getConversationsForUser() []slack.Channel {
-	conversations, _, err := c.userClient.GetConversationsForUser(&slack.GetConversationsForUserParameters{})
+func fallbackText(report Report) string {
+	return strings.Join(report.Period.Title, " ") + " : " + strconv.Itoa(report.Total)
+}
+
+// The first part of the output of a custom template is the top-level message, the rest is replied in its thread.
+func postTemplated(botClient *slack.Client, channelID, message string, blocks []slack.Block, report Report, split bool) (string, error) {
+	type part struct {
+		text   string
+		blocks []slack.Block
+	}
+	parts := []part{}
+	if len(blocks) > 0 {
+		text := fallbackText(report)
+		for i := 0; i < len(blocks); i += MAX_BLOCKS {
+			parts = append(parts, part{text: text, blocks: blocks[i:min(i+MAX_BLOCKS, len(blocks))]})
+		}
+	} else {
+		limit := MAX_MESSAGE_LENGTH
+		if split {
+			limit = MAX_REPLY_LENGTH
+		}
+		for _, chunk := range splitLines(message, limit) {
+			parts = append(parts, part{text: chunk})
+		}
+	}
+	ts, err := postChunked(botClient, channelID, "", parts[0].text, parts[0].blocks)
 	if err != nil {
-		log.Println("can not get channels:", err)
+		return "", err
 	}
-	return conversations
+	for _, p := range parts[1:] {
+		if _, err := postChunked(botClient, channelID, ts, p.text, p.blocks); err != nil {
+			log.Println("can not post reply:", err)
+		}
+	}
+	return ts, nil
 }
 
-func (c *config) makeResult(conversations []slack.Channel) (map[string]map[string]int, map[string]map[string]int, map[string]int) {
-	latest := strconv.FormatInt(time.Date(c.now.Year(), c.now.Month(), c.now.Day(), 0, 0, 0, 0, c.now.Location()).Unix(), 10)
-	oldest := strconv.FormatInt(time.Date(c.yesterDay.Year(), c.yesterDay.Month(), c.yesterDay.Day(), 0, 0, 0, 0, c.yesterDay.Location()).Unix(), 10)
+// postChunked posts text and blocks in the thread of ts, or as a top-level message when ts is empty. As long as Slack
+// answers msg_too_long, it halves the blocks, or the text at line boundaries when there is no block, and posts the second
+// half after the first one, in the thread of the first one for a top-level message. It returns the timestamp of the first message.
+func postChunked(botClient *slack.Client, channelID, ts, text string, blocks []slack.Block) (string, error) {
+	options := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if len(blocks) > 0 {
+		options = append(options, slack.MsgOptionBlocks(blocks...))
+	}
+	if ts != "" {
+		options = append(options, slack.MsgOptionTS(ts))
+	}
+	_, posted, err := botClient.PostMessage(channelID, options...)
+	if !isMsgTooLong(err) {
+		return posted, err
+	}
+	type half struct {
+		text   string
+		blocks []slack.Block
+	}
+	halves := []half{}
+	if len(blocks) > 1 {
+		halves = append(halves, half{text: text, blocks: blocks[:len(blocks)/2]}, half{text: text, blocks: blocks[len(blocks)/2:]})
+	} else if len(blocks) == 0 && len(text) > 1 {
+		for _, chunk := range splitLines(text, len(text)/2) {
+			halves = append(halves, half{text: chunk})
+		}
+	}
+	if len(halves) < 2 {
+		return "", err
+	}
+	first, err := postChunked(botClient, channelID, ts, halves[0].text, halves[0].blocks)
+	if err != nil {
+		return "", err
+	}
+	thread := ts
+	if thread == "" {
+		thread = first
+	}
+	for _, h := range halves[1:] {
+		if _, err := postChunked(botClient, channelID, thread, h.text, h.blocks); err != nil {
+			log.Println("can not post reply:", err)
+		}
+	}
+	return first, nil
+}
 
-	countBySiteByChannel := map[string]map[string]int{}
-	countByHostByChannel := map[string]map[string]int{}
-	countByChannel := map[string]int{}
+func postReply(botClient *slack.Client, channelID, ts, text string) {
+	if _, err := postChunked(botClient, channelID, ts, text, nil); err != nil {
+		log.Println("can not post reply:", err)
+	}
+}
 
-	for _, conversation := range conversations {
-		params := slack.GetConversationHistoryParameters{ChannelID: conversation.ID, Limit: 1000, Latest: latest, Oldest: oldest}
-		conversationHistory, err := c.userClient.GetConversationHistory(&params)
-		if err != nil {
-			log.Println("can not get history channelID:", conversation.ID, err)
-			continue
+// sanitizeAttribute replaces every rune but letters and digits with "_", e.g. "example.com" is "example_com".
+func sanitizeAttribute(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
 		}
+		return '_'
+	}, value)
+}
 
-		i := len(conversationHistory.Messages)
-		countByUser := map[string]int{}
-		countByHost := map[string]int{}
-		for _, message := range conversationHistory.Messages {
-			i += message.ReplyCount
+const (
+	CHART_WIDTH      = 800
+	CHART_ROW_HEIGHT = 20
+	CHART_LABEL_SIZE = 24
+	MAX_CHART_BARS   = 15
+	TREND_DAYS       = 14
+)
 
-			if strings.HasPrefix(message.Msg.Text, "<http") {
-				url, err := url.Parse(strings.Split(message.Msg.Text[1:], "|")[0])
-				if err == nil && url.Host != "" {
-					countByHost[url.Host] += 1
-				}
-			}
+var (
+	chartBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	chartForeground = color.RGBA{0x1d, 0x1c, 0x1d, 0xff}
+	chartAxis       = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	chartBar        = color.RGBA{0x36, 0x7f, 0xd6, 0xff}
+)
 
-			userName := ""
-			if message.Msg.Username != "" {
-				userName = message.Msg.Username
-			} else if message.BotProfile != nil && message.BotProfile.Name != "" {
-				userName = message.BotProfile.Name
-			}
-			if userName == "" {
-				continue
+// newChart returns a white image with a title, drawn with the fixed 7x13 font so that no font has to be installed.
+// The font has only ASCII glyphs, so the labels go through chartLabel.
+func newChart(title string, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, CHART_WIDTH, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(chartBackground), image.Point{}, draw.Src)
+	drawText(img, 10, 20, title)
+	return img
+}
+
+func chartLabel(name, fallback string) string {
+	for _, r := range name {
+		if r > unicode.MaxASCII {
+			return fallback
+		}
+	}
+	return name
+}
+
+// siteLabel returns a site name the chart font can draw, the punycode of a site named with other letters than ASCII.
+func siteLabel(site string) string {
+	ascii, err := idna.Punycode.ToASCII(site)
+	if err != nil {
+		ascii = strings.Map(func(r rune) rune {
+			if r > unicode.MaxASCII {
+				return '?'
 			}
-			countByUser[userName] += 1
+			return r
+		}, site)
+	}
+	return chartLabel(site, ascii)
+}
+
+func drawText(img *image.RGBA, x, y int, text string) {
+	d := font.Drawer{Dst: img, Src: image.NewUniform(chartForeground), Face: basicfont.Face7x13, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	steps := max(abs(x1-x0), abs(y1-y0), 1)
+	for i := 0; i <= steps; i++ {
+		x := x0 + (x1-x0)*i/steps
+		y := y0 + (y1-y0)*i/steps
+		fillRect(img, image.Rect(x, y, x+2, y+2), c)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func truncate(text string, size int) string {
+	runes := []rune(text)
+	if len(runes) <= size {
+		return text
+	}
+	return string(runes[:size-1]) + "~"
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func barChart(title string, counts []Count) ([]byte, error) {
+	if len(counts) > MAX_CHART_BARS {
+		counts = counts[:MAX_CHART_BARS]
+	}
+	img := newChart(title, 40+len(counts)*CHART_ROW_HEIGHT)
+	left := 10 + CHART_LABEL_SIZE*7 + 10
+	right := CHART_WIDTH - 60
+	maxCount := 1
+	for _, c := range counts {
+		maxCount = max(maxCount, c.Count)
+	}
+	for i, c := range counts {
+		y := 40 + i*CHART_ROW_HEIGHT
+		drawText(img, 10, y+14, truncate(c.Name, CHART_LABEL_SIZE))
+		width := (right - left) * c.Count / maxCount
+		fillRect(img, image.Rect(left, y+3, left+width, y+CHART_ROW_HEIGHT-3), chartBar)
+		drawText(img, left+width+6, y+14, strconv.Itoa(c.Count))
+	}
+	return encodePNG(img)
+}
+
+func lineChart(title string, labels []string, values []int) ([]byte, error) {
+	height := 300
+	img := newChart(title, height)
+	left, right, top, bottom := 50, CHART_WIDTH-20, 40, height-30
+	fillRect(img, image.Rect(left, bottom, right, bottom+1), chartAxis)
+	fillRect(img, image.Rect(left, top, left+1, bottom), chartAxis)
+	maxValue := 1
+	for _, v := range values {
+		maxValue = max(maxValue, v)
+	}
+	drawText(img, 10, top+10, strconv.Itoa(maxValue))
+	drawText(img, 10, bottom, "0")
+	if len(labels) > 0 {
+		drawText(img, left, bottom+20, labels[0])
+		drawText(img, right-len(labels[len(labels)-1])*7, bottom+20, labels[len(labels)-1])
+	}
+	point := func(i int) (int, int) {
+		x := left
+		if len(values) > 1 {
+			x = left + (right-left)*i/(len(values)-1)
+		}
+		return x, bottom - (bottom-top)*values[i]/maxValue
+	}
+	for i := range values {
+		x, y := point(i)
+		fillRect(img, image.Rect(x-3, y-3, x+3, y+3), chartBar)
+		if i > 0 {
+			px, py := point(i - 1)
+			drawLine(img, px, py, x, y, chartBar)
 		}
+	}
+	return encodePNG(img)
+}
 
-		countByChannel[conversation.ID] = i
-		countBySiteByChannel[conversation.ID] = countByUser
-		countByHostByChannel[conversation.ID] = countByHost
+// trendSeries returns the totals of the TREND_DAYS days up to the last day of the period,
+// from the result inside the period and from the history store before it. Both count the replies.
+func (c *config) trendSeries(r result) ([]string, []int) {
+	last := addDays(c.period.to, -1)
+	countByDay := map[string]int{}
+	for _, countByDayOfChannel := range r.countByDayByChannel {
+		for day, count := range countByDayOfChannel {
+			countByDay[day] += count
+		}
+	}
+	labels, values := []string{}, []int{}
+	for i := TREND_DAYS - 1; i >= 0; i-- {
+		day := addDays(last, -i)
+		label := day.Format("2006-01-02")
+		value := total(c.history[label].CountByChannel)
+		if !day.Before(c.period.from) {
+			value = countByDay[label]
+		}
+		labels = append(labels, label)
+		values = append(values, value)
 	}
-	return countBySiteByChannel, countByHostByChannel, countByChannel
+	return labels, values
 }
 
-func (c *config) createMessage(countBySiteByChannel map[string]map[string]int, countByChannel map[string]int, channelById map[string]slack.Channel) string {
-	count := 0
-	for _, v := range countByChannel {
-		count += v
+func (c *config) uploadCharts(botClient *slack.Client, channelID, ts string, report Report, r result) {
+	channels := []Count{}
+	for _, channel := range report.Channels {
+		// a channel named with other letters than ASCII is labeled with its ID
+		channels = append(channels, Count{Name: chartLabel("#"+channel.Name, channel.ID), Count: channel.Total})
 	}
-	var message = c.yesterDay.Format("2006-01-02") + "\n" + c.yesterDay.Format("Monday") + "\n" + strconv.FormatInt(int64(count), 10) + "\n"
-	for _, channel := range channelById {
-		mapBySite, ok := countBySiteByChannel[channel.ID]
-		if !ok {
+	sites := []Count{}
+	for _, host := range report.Hosts {
+		sites = append(sites, Count{Name: siteLabel(host.Name), Count: host.Count})
+	}
+	title := strings.Join(report.Period.Title, " ")
+	labels, values := c.trendSeries(r)
+
+	charts := []struct {
+		filename string
+		title    string
+		render   func(title string) ([]byte, error)
+	}{
+		{filename: "channels.png", title: "Messages by channel " + title, render: func(title string) ([]byte, error) { return barChart(title, channels) }},
+		{filename: "sites.png", title: "Links by site " + title, render: func(title string) ([]byte, error) { return barChart(title, sites) }},
+		{filename: "trend.png", title: "Messages in the last " + strconv.Itoa(TREND_DAYS) + " days", render: func(title string) ([]byte, error) { return lineChart(title, labels, values) }},
+	}
+	for _, chart := range charts {
+		b, err := chart.render(chart.title)
+		if err != nil {
+			log.Println("can not render chart:", chart.filename, err)
 			continue
 		}
-		if len(mapBySite) == 0 {
-			continue
+		_, err = botClient.UploadFile(slack.UploadFileParameters{
+			Reader:          bytes.NewReader(b),
+			FileSize:        len(b),
+			Filename:        chart.filename,
+			Title:           chart.title,
+			Channel:         channelID,
+			ThreadTimestamp: ts,
+		})
+		if err != nil {
+			log.Println("can not upload chart:", chart.filename, err)
 		}
-		message += "\n<#" + channel.ID + ">\n"
-		for k, v := range mapBySite {
-			message += k + " : " + strconv.FormatInt(int64(v), 10) + "\n"
+	}
+}
+
+// periodExporter stamps the data points with the period instead of the time of the export,
+// so that a point is on the day of SUMMARY_TZ it counts.
+type periodExporter struct {
+	sdkmetric.Exporter
+	period period
+}
+
+func (e periodExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	for i := range rm.ScopeMetrics {
+		for j := range rm.ScopeMetrics[i].Metrics {
+			switch data := rm.ScopeMetrics[i].Metrics[j].Data.(type) {
+			case metricdata.Sum[int64]:
+				stampDataPoints(data.DataPoints, e.period)
+			case metricdata.Gauge[int64]:
+				stampDataPoints(data.DataPoints, e.period)
+			case metricdata.Histogram[float64]:
+				for k := range data.DataPoints {
+					data.DataPoints[k].StartTime, data.DataPoints[k].Time = e.period.from, e.period.to
+				}
+			}
 		}
 	}
-	return message
+	return e.Exporter.Export(ctx, rm)
+}
+
+func stampDataPoints[N int64 | float64](points []metricdata.DataPoint[N], p period) {
+	for i := range points {
+		points[i].StartTime, points[i].Time = p.from, p.to
+	}
 }
 
-func sendMetrics(countByHostByChannel map[string]map[string]int, channelById map[string]slack.Channel) {
+func sendMetrics(r result, countByHostByChannel map[string]map[string]int, feeds []feed, channelById map[string]slack.Channel, p period) {
 	otelExporterEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
 	if otelExporterEndpoint == "" {
 		// OTEL_EXPORTER_OTLP_METRICS_ENDPOINT is optional, so no need to log
@@ -174,7 +2994,7 @@ func sendMetrics(countByHostByChannel map[string]map[string]int, channelById map
 		log.Println("failed to create resource:", err)
 	}
 
-	reader := sdkmetric.NewPeriodicReader(exporter)
+	reader := sdkmetric.NewPeriodicReader(periodExporter{Exporter: exporter, period: p})
 	provider := sdkmetric.NewMeterProvider(
 		sdkmetric.WithReader(reader),
 		sdkmetric.WithResource(res),
@@ -201,14 +3021,136 @@ func sendMetrics(countByHostByChannel map[string]map[string]int, channelById map
 		if !ok {
 			continue
 		}
-		sanitizedChannel := strings.ReplaceAll(strings.ReplaceAll(channel.Name, ".", "_"), "-", "_")
-		for host, v := range hostMap {
-			sanitizedHost := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(host, ".", "_"), "-", "_"), "www_", "")
+		for site, v := range hostMap {
 			opts := metric.WithAttributes(
-				attribute.String("host", sanitizedHost),
-				attribute.String("channel", sanitizedChannel),
+				attribute.String("host", sanitizeAttribute(site)),
+				attribute.String("channel", sanitizeAttribute(channel.Name)),
 			)
 			counter.Add(ctx, int64(v), opts)
 		}
 	}
+
+	reactionCounter, err := meter.Int64Counter("slack.reaction.count",
+		metric.WithDescription("Reactions count by emoji"),
+	)
+	if err != nil {
+		log.Println("failed to create counter slack.reaction.count:", err)
+		return
+	}
+	articleReactionCounter, err := meter.Int64Counter("rss.article.reaction.count",
+		metric.WithDescription("Reactions count on messages linking to a site"),
+	)
+	if err != nil {
+		log.Println("failed to create counter rss.article.reaction.count:", err)
+		return
+	}
+	for channelID, channel := range channelById {
+		for emoji, v := range r.countByEmojiByChannel[channelID] {
+			reactionCounter.Add(ctx, int64(v), metric.WithAttributes(
+				attribute.String("emoji", emoji),
+				attribute.String("channel", sanitizeAttribute(channel.Name)),
+			))
+		}
+		for site, v := range r.countReactionByHostByChannel[channelID] {
+			articleReactionCounter.Add(ctx, int64(v), metric.WithAttributes(
+				attribute.String("host", sanitizeAttribute(site)),
+				attribute.String("channel", sanitizeAttribute(channel.Name)),
+			))
+		}
+	}
+
+	stalledGauge, err := meter.Int64Gauge("feed_stalled",
+		metric.WithDescription("1 when a feed stays below its baseline, 0 otherwise"),
+	)
+	if err != nil {
+		log.Println("failed to create gauge feed_stalled:", err)
+		return
+	}
+	for _, f := range feeds {
+		channel, ok := channelById[f.channelID]
+		if !ok {
+			continue
+		}
+		stalled := int64(0)
+		if f.stalled {
+			stalled = 1
+		}
+		stalledGauge.Record(ctx, stalled, metric.WithAttributes(
+			attribute.String("feed", sanitizeAttribute(f.author)),
+			attribute.String("channel", sanitizeAttribute(channel.Name)),
+		))
+	}
+
+	hourlyGauge, err := meter.Int64Gauge("slack.message.hourly",
+		metric.WithDescription("Messages count by hour of day"),
+	)
+	if err != nil {
+		log.Println("failed to create gauge slack.message.hourly:", err)
+		return
+	}
+	for channelID, countByHour := range r.countByHourByChannel {
+		channel, ok := channelById[channelID]
+		if !ok {
+			continue
+		}
+		for hour, v := range countByHour {
+			hourlyGauge.Record(ctx, int64(v), metric.WithAttributes(
+				attribute.String("hour", fmt.Sprintf("%02d", hour)),
+				attribute.String("channel", sanitizeAttribute(channel.Name)),
+			))
+		}
+	}
+
+	firstReplyHistogram, err := meter.Float64Histogram("slack.thread.first_reply.duration",
+		metric.WithDescription("Time from the start of a thread to its first reply"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		log.Println("failed to create histogram slack.thread.first_reply.duration:", err)
+		return
+	}
+	resolutionHistogram, err := meter.Float64Histogram("slack.thread.resolution.duration",
+		metric.WithDescription("Time from the start of a thread to its resolution"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		log.Println("failed to create histogram slack.thread.resolution.duration:", err)
+		return
+	}
+	unansweredGauge, err := meter.Int64Gauge("slack.thread.unanswered",
+		metric.WithDescription("Threads without a reply"),
+	)
+	if err != nil {
+		log.Println("failed to create gauge slack.thread.unanswered:", err)
+		return
+	}
+	for channelID, res := range r.responsesByChannel {
+		channel, ok := channelById[channelID]
+		if !ok {
+			continue
+		}
+		opts := metric.WithAttributes(
+			attribute.String("channel", sanitizeAttribute(channel.Name)),
+		)
+		for _, d := range res.firstReply {
+			firstReplyHistogram.Record(ctx, d.Seconds(), opts)
+		}
+		for _, d := range res.resolved {
+			resolutionHistogram.Record(ctx, d.Seconds(), opts)
+		}
+		unansweredGauge.Record(ctx, int64(res.unanswered), opts)
+	}
+
+	hitsGauge, err := meter.Int64Gauge("slack.search.hits",
+		metric.WithDescription("Messages of the reported channels matching a saved query"),
+	)
+	if err != nil {
+		log.Println("failed to create gauge slack.search.hits:", err)
+		return
+	}
+	for _, t := range r.trackers {
+		hitsGauge.Record(ctx, int64(t.hits), metric.WithAttributes(
+			attribute.String("query", sanitizeAttribute(t.name)),
+		))
+	}
 }
@@ -2,16 +2,30 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slacktest"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 //go:embed testdata
@@ -79,20 +93,182 @@ func TestGetConversationsForUser(t *testing.T) {
 	}
 }
 
+func TestMakePeriod(t *testing.T) {
+	type args struct {
+		name string
+		from string
+		to   string
+		now  time.Time
+	}
+	type want struct {
+		period period
+		err    string
+	}
+	// 2023-01-11 is Wednesday
+	now := time.Date(2023, 1, 11, 9, 30, 0, 0, time.UTC)
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	newYork, _ := time.LoadLocation("America/New_York")
+	// DST in Sao Paulo started at midnight on 2018-11-04
+	saoPaulo, _ := time.LoadLocation("America/Sao_Paulo")
+	tests := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "default",
+			args: args{name: "", now: now},
+			want: want{period: period{name: "day", from: time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 11, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "day",
+			args: args{name: "day", now: now},
+			want: want{period: period{name: "day", from: time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 11, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "week",
+			args: args{name: "week", now: now},
+			want: want{period: period{name: "week", from: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 9, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "weekOnSunday",
+			args: args{name: "week", now: time.Date(2023, 1, 15, 9, 30, 0, 0, time.UTC)},
+			want: want{period: period{name: "week", from: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 9, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "month",
+			args: args{name: "month", now: now},
+			want: want{period: period{name: "month", from: time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "custom",
+			args: args{name: "custom", from: "2023-01-01", to: "2023-01-03", now: now},
+			want: want{period: period{name: "custom", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 4, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "customInvalidFrom",
+			args: args{name: "custom", from: "2023/01/01", to: "2023-01-03", now: now},
+			want: want{err: "PERIOD_FROM is invalid: parsing time \"2023/01/01\" as \"2006-01-02\": cannot parse \"/01/01\" as \"-\""},
+		},
+		{
+			name: "customToBeforeFrom",
+			args: args{name: "custom", from: "2023-01-03", to: "2023-01-01", now: now},
+			want: want{err: "PERIOD_TO 2023-01-01 is before PERIOD_FROM 2023-01-03"},
+		},
+		{
+			name: "dayInTokyo",
+			args: args{name: "day", now: time.Date(2023, 1, 11, 0, 30, 0, 0, tokyo)},
+			want: want{period: period{name: "day", from: time.Date(2023, 1, 10, 0, 0, 0, 0, tokyo), to: time.Date(2023, 1, 11, 0, 0, 0, 0, tokyo)}},
+		},
+		{
+			name: "dayStartingDST",
+			args: args{name: "day", now: time.Date(2023, 3, 13, 9, 0, 0, 0, newYork)},
+			want: want{period: period{name: "day", from: time.Date(2023, 3, 12, 5, 0, 0, 0, time.UTC), to: time.Date(2023, 3, 13, 4, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "dayEndingDST",
+			args: args{name: "day", now: time.Date(2023, 11, 6, 9, 0, 0, 0, newYork)},
+			want: want{period: period{name: "day", from: time.Date(2023, 11, 5, 4, 0, 0, 0, time.UTC), to: time.Date(2023, 11, 6, 5, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "dayWithoutMidnight",
+			args: args{name: "day", now: time.Date(2018, 11, 5, 9, 0, 0, 0, saoPaulo)},
+			want: want{period: period{name: "day", from: time.Date(2018, 11, 4, 3, 0, 0, 0, time.UTC), to: time.Date(2018, 11, 5, 2, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "weekOverDST",
+			args: args{name: "week", now: time.Date(2023, 3, 13, 9, 0, 0, 0, newYork)},
+			want: want{period: period{name: "week", from: time.Date(2023, 3, 6, 5, 0, 0, 0, time.UTC), to: time.Date(2023, 3, 13, 4, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "unknown",
+			args: args{name: "year", now: now},
+			want: want{err: "PERIOD year is not one of day, week, month or custom"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := makePeriod(tt.args.name, tt.args.from, tt.args.to, tt.args.now)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("makePeriod() err = \n%v, want \n%v", gotErr, tt.want.err)
+			}
+			if got.name != tt.want.period.name || !got.from.Equal(tt.want.period.from) || !got.to.Equal(tt.want.period.to) {
+				t.Errorf("makePeriod() = \n%v, want \n%v", got, tt.want.period)
+			}
+		})
+	}
+}
+
+func TestMakeLocation(t *testing.T) {
+	type want struct {
+		location string
+		err      string
+	}
+	tests := []struct {
+		name string
+		arg  string
+		want want
+	}{
+		{
+			name: "empty",
+			arg:  "",
+			want: want{location: "Local"},
+		},
+		{
+			name: "tokyo",
+			arg:  "Asia/Tokyo",
+			want: want{location: "Asia/Tokyo"},
+		},
+		{
+			name: "invalid",
+			arg:  "Asia/Nowhere",
+			want: want{err: "unknown time zone Asia/Nowhere"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := makeLocation(tt.arg)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("makeLocation() err = %v, want %v", gotErr, tt.want.err)
+			}
+			if err == nil && got.String() != tt.want.location {
+				t.Errorf("makeLocation() = %v, want %v", got, tt.want.location)
+			}
+		})
+	}
+}
+
 func TestMakeResult(t *testing.T) {
 	type args struct {
 		conversations []slack.Channel
-		now           time.Time
-		yesterDay     time.Time
+		period        period
+		withReplies   bool
 	}
 	type want struct {
 		countBySiteByChannel map[string]map[string]int
 		countByHostByChannel map[string]map[string]int
 		countByChannel       map[string]int
+		countByDayByChannel  map[string]map[string]int
+		countByHour          map[string]map[int]int
+		channelsByLink       map[string][]string
+		countByHuman         map[string]map[string]int
+		countByEmoji         map[string]map[string]int
+		countReactionByHost  map[string]map[string]int
+		reacted              []reacted
+		articles             map[string][]article
+		threads              []thread
 		err                  string
 	}
-	now := time.Now()
-	yesterDay := now.AddDate(0, 0, -1)
+	day, _ := makePeriod("day", "", "", time.Now())
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
 	tests := []struct {
 		name   string
 		args   args
@@ -101,31 +277,86 @@ func TestMakeResult(t *testing.T) {
 	}{
 		{
 			name:   "aMessage",
-			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, now: now, yesterDay: yesterDay},
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
 			apiRes: "testdata/conversationsHistory/aMessage.json",
 			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {}}, countByChannel: map[string]int{"ABCDEF12345": 1}},
 		},
 		{
 			name:   "aMessageWithLink",
-			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, now: now, yesterDay: yesterDay},
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
 			apiRes: "testdata/conversationsHistory/messageWithLink.json",
-			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 1}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1}}, countByChannel: map[string]int{"ABCDEF12345": 1}},
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 1}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1}}, countByChannel: map[string]int{"ABCDEF12345": 1}, articles: map[string][]article{"ABCDEF12345": {{link: "https://example.com", title: "text A", site: "example.com"}}}},
 		},
 		{
 			name:   "aMessageWithInvalidLink",
-			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, now: now, yesterDay: yesterDay},
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
 			apiRes: "testdata/conversationsHistory/messageWithInvalidLink.json",
 			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"ABCDEF123": 1}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {}}, countByChannel: map[string]int{"ABCDEF12345": 1}},
 		},
 		{
 			name:   "twoMessageInDefferentChannel",
-			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}}, {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, now: now, yesterDay: yesterDay},
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}}, {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
 			apiRes: "testdata/conversationsHistory/aMessage.json",
 			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {}, "ABCDEF01234": {}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {}, "ABCDEF01234": {}}, countByChannel: map[string]int{"ABCDEF01234": 1, "ABCDEF12345": 1}},
 		},
+		{
+			name:   "aLinkInDefferentChannel",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}}, {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
+			apiRes: "testdata/conversationsHistory/messageWithLink.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 1}, "ABCDEF01234": {"bot-user-name": 1}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1}, "ABCDEF01234": {"example.com": 1}}, countByChannel: map[string]int{"ABCDEF01234": 1, "ABCDEF12345": 1}, channelsByLink: map[string][]string{"https://example.com": {"ABCDEF01234", "ABCDEF12345"}}},
+		},
+		{
+			name:   "humanMessages",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
+			apiRes: "testdata/conversationsHistory/humanMessages.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 1, "deploy-bot": 1}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1}}, countByChannel: map[string]int{"ABCDEF12345": 7}, countByHuman: map[string]map[string]int{"ABCDEF12345": {"U0123456789": 3, "U3456789012": 1}}},
+		},
+		{
+			name:   "reactedMessages",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
+			apiRes: "testdata/conversationsHistory/reactedMessages.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 3}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 2, "example.org": 2}}, countByChannel: map[string]int{"ABCDEF12345": 3}, countByEmoji: map[string]map[string]int{"ABCDEF12345": {"+1": 5, "eyes": 2}}, countReactionByHost: map[string]map[string]int{"ABCDEF12345": {"example.com": 3, "example.org": 4}}, reacted: []reacted{{channelID: "ABCDEF12345", ts: "1512085960.000216", reactionCount: 4, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"}, {channelID: "ABCDEF12345", ts: "1512085950.000216", reactionCount: 3, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"}}},
+		},
+		{
+			name:   "messagesInTwoPages",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
+			apiRes: "testdata/conversationsHistory/firstPage.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 3}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 2, "example.org": 1}}, countByChannel: map[string]int{"ABCDEF12345": 3}},
+		},
+		{
+			name:   "aMessageWithRepliesNotFetched",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
+			apiRes: "testdata/conversationsHistory/aMessageWithReplies.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 1}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1}}, countByChannel: map[string]int{"ABCDEF12345": 3}},
+		},
+		{
+			name:   "aMessageWithRepliesFetched",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day, withReplies: true},
+			apiRes: "testdata/conversationsHistory/aMessageWithReplies.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 1, "replier": 2}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1, "example.org": 1}}, countByChannel: map[string]int{"ABCDEF12345": 3}, threads: []thread{{channelID: "ABCDEF12345", ts: "1512085950.000216", replyCount: 2, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"}}},
+		},
+		{
+			name:   "messagesInTwoDays",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: period{name: "custom", from: time.Date(2017, 11, 30, 0, 0, 0, 0, time.UTC), to: time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC)}},
+			apiRes: "testdata/conversationsHistory/messagesInTwoDays.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 3}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {}}, countByChannel: map[string]int{"ABCDEF12345": 4}, countByDayByChannel: map[string]map[string]int{"ABCDEF12345": {"2017-11-30": 2, "2017-12-01": 2}}, countByHour: map[string]map[int]int{"ABCDEF12345": {0: 2, 12: 1, 23: 1}}},
+		},
+		{
+			// the replies are bucketed by their own time, not by the time of their parent
+			name:   "messagesInTwoDaysWithReplies",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: period{name: "custom", from: time.Date(2017, 11, 30, 0, 0, 0, 0, time.UTC), to: time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC)}, withReplies: true},
+			apiRes: "testdata/conversationsHistory/messagesInTwoDays.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 3, "replier": 2}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {}}, countByChannel: map[string]int{"ABCDEF12345": 5}, countByDayByChannel: map[string]map[string]int{"ABCDEF12345": {"2017-11-30": 2, "2017-12-01": 3}}, countByHour: map[string]map[int]int{"ABCDEF12345": {0: 1, 12: 2, 23: 2}}, threads: []thread{{channelID: "ABCDEF12345", ts: "1512086400.000100", replyCount: 2, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"}}},
+		},
+		{
+			name:   "messagesInTwoDaysInTokyo",
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: period{name: "custom", from: time.Date(2017, 11, 30, 0, 0, 0, 0, tokyo), to: time.Date(2017, 12, 2, 0, 0, 0, 0, tokyo)}},
+			apiRes: "testdata/conversationsHistory/messagesInTwoDays.json",
+			want:   want{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"bot-user-name": 3}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {}}, countByChannel: map[string]int{"ABCDEF12345": 4}, countByDayByChannel: map[string]map[string]int{"ABCDEF12345": {"2017-11-30": 1, "2017-12-01": 3}}, countByHour: map[string]map[int]int{"ABCDEF12345": {8: 1, 9: 2, 21: 1}}},
+		},
 		{
 			name:   "twoMessageInDefferentChannelWithError",
-			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}}, {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, now: now, yesterDay: yesterDay},
+			args:   args{conversations: []slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}}, {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, period: day},
 			apiRes: "testdata/conversationsHistory/error.json",
 			want:   want{countByHostByChannel: map[string]map[string]int{}, countByChannel: map[string]int{}, err: "can not get history channelID: ABCDEF01234 channel_not_found\ncan not get history channelID: ABCDEF12345 channel_not_found"},
 		},
@@ -133,8 +364,33 @@ func TestMakeResult(t *testing.T) {
 
 	for _, tt := range tests {
 		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
-			c.Handle("/conversations.history", func(w http.ResponseWriter, _ *http.Request) {
-				res, _ := testdata.ReadFile(tt.apiRes)
+			c.Handle("/conversations.history", func(w http.ResponseWriter, r *http.Request) {
+				apiRes := tt.apiRes
+				if cursor := r.FormValue("cursor"); cursor != "" {
+					// fixtures name the next page after its cursor
+					apiRes = "testdata/conversationsHistory/" + cursor + ".json"
+				}
+				res, _ := testdata.ReadFile(apiRes)
+				w.Write(res)
+			})
+			c.Handle("/conversations.replies", func(w http.ResponseWriter, r *http.Request) {
+				path := "testdata/conversationsReplies/replies.json"
+				if r.FormValue("ts") == "1512086400.000100" {
+					path = "testdata/conversationsReplies/lateReplies.json"
+				}
+				res, _ := testdata.ReadFile(path)
+				w.Write(res)
+			})
+			c.Handle("/chat.getPermalink", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/chatGetPermalink/ok.json")
+				w.Write(res)
+			})
+			c.Handle("/users.list", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/usersList/ok.json")
+				w.Write(res)
+			})
+			c.Handle("/users.info", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile("testdata/usersInfo/ok.json")
 				w.Write(res)
 			})
 		})
@@ -152,14 +408,16 @@ func TestMakeResult(t *testing.T) {
 				buf.Reset()
 			}()
 
-			c := &config{userClient: client}
-			actualCountBySiteByChannel, actualCountByHostByChannel, actualCountByChannel := c.makeResult(tt.args.conversations)
+			users, _ := loadUserCache(client, "", DEFAULT_USER_CACHE_TTL)
+			c := &config{userClient: client, period: tt.args.period, withReplies: tt.args.withReplies, users: users}
+			actual := c.makeResult(tt.args.conversations)
+			actualCountBySiteByChannel, actualCountByHostByChannel, actualCountByChannel := actual.countBySiteByChannel, actual.countByHostByChannel, actual.countByChannel
 			if len(actualCountByChannel) != len(tt.want.countByChannel) {
 				t.Errorf("len(createChannels().countByChannel) = \n%v, want \n%v", actualCountByChannel, tt.want.countByChannel)
 			}
 			if len(actualCountByChannel) > 0 {
 				for k, v := range tt.want.countByChannel {
-					if v != tt.want.countByChannel[k] {
+					if v != actualCountByChannel[k] {
 						t.Errorf("createChannels() countByChannel = \n%v, want \n%v", actualCountByChannel[k], v)
 					}
 
@@ -196,6 +454,70 @@ func TestMakeResult(t *testing.T) {
 
 				}
 			}
+			for k, v := range tt.want.countByDayByChannel {
+				for kk, vv := range v {
+					if vv != actual.countByDayByChannel[k][kk] {
+						t.Errorf("createChannels() countByDayByChannel %v.%v = %v, want %v", k, kk, actual.countByDayByChannel[k][kk], vv)
+					}
+				}
+			}
+			// the days and the hours add up to the total of the channel
+			for k, count := range actual.countByChannel {
+				if sum := total(actual.countByDayByChannel[k]); sum != count {
+					t.Errorf("createChannels() sum of countByDayByChannel %v = %v, want %v", k, sum, count)
+				}
+				sum := 0
+				for _, c := range actual.countByHourByChannel[k] {
+					sum += c
+				}
+				if sum != count {
+					t.Errorf("createChannels() sum of countByHourByChannel %v = %v, want %v", k, sum, count)
+				}
+			}
+			for k, v := range tt.want.countByHour {
+				for hour, count := range actual.countByHourByChannel[k] {
+					if count != v[hour] {
+						t.Errorf("createChannels() countByHourByChannel %v[%v] = %v, want %v", k, hour, count, v[hour])
+					}
+				}
+			}
+			for k, v := range tt.want.countByHuman {
+				if fmt.Sprint(actual.countByHumanByChannel[k]) != fmt.Sprint(v) {
+					t.Errorf("createChannels() countByHumanByChannel %v = %v, want %v", k, actual.countByHumanByChannel[k], v)
+				}
+			}
+			for k, v := range tt.want.countByEmoji {
+				if fmt.Sprint(actual.countByEmojiByChannel[k]) != fmt.Sprint(v) {
+					t.Errorf("createChannels() countByEmojiByChannel %v = %v, want %v", k, actual.countByEmojiByChannel[k], v)
+				}
+			}
+			for k, v := range tt.want.countReactionByHost {
+				if fmt.Sprint(actual.countReactionByHostByChannel[k]) != fmt.Sprint(v) {
+					t.Errorf("createChannels() countReactionByHostByChannel %v = %v, want %v", k, actual.countReactionByHostByChannel[k], v)
+				}
+			}
+			for k, v := range tt.want.articles {
+				if fmt.Sprint(actual.articlesByChannel[k]) != fmt.Sprint(v) {
+					t.Errorf("createChannels() articlesByChannel %v = %v, want %v", k, actual.articlesByChannel[k], v)
+				}
+			}
+			if tt.want.reacted != nil && fmt.Sprint(actual.reacted) != fmt.Sprint(tt.want.reacted) {
+				t.Errorf("createChannels() reacted = %v, want %v", actual.reacted, tt.want.reacted)
+			}
+			for k, v := range tt.want.channelsByLink {
+				if strings.Join(actual.channelsByLink[k], ",") != strings.Join(v, ",") {
+					t.Errorf("createChannels() channelsByLink %v = %v, want %v", k, actual.channelsByLink[k], v)
+				}
+			}
+			if len(actual.threads) != len(tt.want.threads) {
+				t.Errorf("len(createChannels().threads) = %v, want %v", actual.threads, tt.want.threads)
+			} else {
+				for i, v := range tt.want.threads {
+					if v != actual.threads[i] {
+						t.Errorf("createChannels() threads[%v] = %v, want %v", i, actual.threads[i], v)
+					}
+				}
+			}
 			gotPrint := strings.TrimRight(buf.String(), "\n")
 			if gotPrint != tt.want.err {
 				t.Errorf("createChannels() = \n%v, want \n%v", gotPrint, tt.want.err)
@@ -206,13 +528,25 @@ func TestMakeResult(t *testing.T) {
 
 func TestCreateMessage(t *testing.T) {
 	type args struct {
-		yesterDay          time.Time
+		period             period
+		history            string
 		mapBySiteByChannel map[string]map[string]int
 		mapByChannel       map[string]int
+		mapByDayByChannel  map[string]map[string]int
+		mapByHostByChannel map[string]map[string]int
+		mapByHuman         map[string]map[string]int
+		anonymize          bool
+		mapByEmoji         map[string]map[string]int
+		reacted            []reacted
+		mapByHourByChannel map[string][]int
+		threads            []thread
 		channelMap         map[string]slack.Channel
 	}
 
-	aDay := time.Date(2023, 1, 1, 0, 0, 0, 0, time.Now().Location())
+	aDay := period{name: "day", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.Now().Location()), to: time.Date(2023, 1, 2, 0, 0, 0, 0, time.Now().Location())}
+	aWeek := period{name: "week", from: time.Date(2023, 1, 2, 0, 0, 0, 0, time.Now().Location()), to: time.Date(2023, 1, 9, 0, 0, 0, 0, time.Now().Location())}
+	aMonth := period{name: "month", from: time.Date(2023, 2, 1, 0, 0, 0, 0, time.Now().Location()), to: time.Date(2023, 3, 1, 0, 0, 0, 0, time.Now().Location())}
+	someDays := period{name: "custom", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.Now().Location()), to: time.Date(2023, 1, 4, 0, 0, 0, 0, time.Now().Location())}
 
 	tests := []struct {
 		name string
@@ -221,27 +555,2156 @@ func TestCreateMessage(t *testing.T) {
 	}{
 		{
 			name: "channelsIsNil",
-			args: args{yesterDay: aDay},
+			args: args{period: aDay},
 			want: "2023-01-01\nSunday\n0\n",
 		},
 		{
 			name: "channelsIsZero",
-			args: args{yesterDay: aDay, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{}},
+			args: args{period: aDay, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{}},
 			want: "2023-01-01\nSunday\n0\n",
 		},
 		{
 			name: "channelsIsPresent",
-			args: args{yesterDay: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteB": 2, "SiteA": 1}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 1}},
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteB": 2, "SiteA": 1}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 1}},
 			want: "2023-01-01\nSunday\n1\n\n<#ABCDEF12345>\nSiteB : 2\nSiteA : 1\n",
 		},
+		{
+			name: "sitesArePresent",
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 3}}, mapByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1, "Example Blog": 2}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 3}},
+			want: "2023-01-01\nSunday\n3\n\n<#ABCDEF12345>\nSiteA : 3\nsites : Example Blog 2 / example.com 1\n",
+		},
+		{
+			name: "humansArePresent",
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 1}}, mapByHuman: map[string]map[string]int{"ABCDEF12345": {"alice": 1, "bob": 2}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 3}},
+			want: "2023-01-01\nSunday\n3\n\n<#ABCDEF12345>\nSiteA : 1\nhumans : bob 2 / alice 1\n",
+		},
+		{
+			name: "humansAreAnonymized",
+			args: args{period: aDay, mapByHuman: map[string]map[string]int{"ABCDEF12345": {"alice": 1, "bob": 2}, "ABCDEF01234": {"alice": 3}}, anonymize: true, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}, "ABCDEF01234": {GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 3, "ABCDEF01234": 3}},
+			want: "2023-01-01\nSunday\n6\n\n<#ABCDEF01234>\nhumans : user 1 3\n\n<#ABCDEF12345>\nhumans : user 2 2 / user 1 1\n",
+		},
+		{
+			name: "reactionsArePresent",
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 2}}, mapByEmoji: map[string]map[string]int{"ABCDEF12345": {"eyes": 2, "+1": 5}}, reacted: []reacted{{channelID: "ABCDEF12345", ts: "1512085960.000216", reactionCount: 5, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085960000216"}, {channelID: "ABCDEF12345", ts: "1512085950.000216", reactionCount: 2}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 2}},
+			want: "2023-01-01\nSunday\n2\n\n<#ABCDEF12345>\nSiteA : 2\nreactions : 7\n\nMost reacted messages\n<#ABCDEF12345> : 5 reactions https://example.slack.com/archives/ABCDEF12345/p1512085960000216\n<#ABCDEF12345> : 2 reactions\n\nTop emoji\n:+1: 5 / :eyes: 2\n",
+		},
+		{
+			name: "hoursArePresent",
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 5}}, mapByHourByChannel: map[string][]int{"ABCDEF12345": {0, 0, 0, 0, 0, 0, 0, 0, 1, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 5}},
+			want: "2023-01-01\nSunday\n5\n\n<#ABCDEF12345>\nhours : `        ▂█              ` peak 09:00\nSiteA : 5\n",
+		},
+		{
+			name: "threadsArePresent",
+			args: args{period: aDay, mapByChannel: map[string]int{"ABCDEF12345": 3}, threads: []thread{{channelID: "ABCDEF12345", ts: "1512085950.000216", replyCount: 2, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"}, {channelID: "ABCDEF12345", ts: "1512085960.000216", replyCount: 1}}},
+			want: "2023-01-01\nSunday\n3\n\nMost discussed threads\n<#ABCDEF12345> : 2 replies https://example.slack.com/archives/ABCDEF12345/p1512085950000216\n<#ABCDEF12345> : 1 replies\n",
+		},
+		{
+			name: "channelsAreSortedByCount",
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 1}, "ABCDEF01234": {"SiteB": 1, "SiteC": 1, "SiteA": 3}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}, "ABCDEF01234": {GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 1, "ABCDEF01234": 5}},
+			want: "2023-01-01\nSunday\n6\n\n<#ABCDEF01234>\nSiteA : 3\nSiteB : 1\nSiteC : 1\n\n<#ABCDEF12345>\nSiteA : 1\n",
+		},
+		{
+			name: "withHistory",
+			args: args{period: aDay, history: "testdata/history/twoDays.jsonl", mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 2}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}, mapByChannel: map[string]int{"ABCDEF12345": 2}},
+			want: "2023-01-01\nSunday\n2 d/d ▼2 (-50%) w/w ±0 (0%)\n\n<#ABCDEF12345> d/d ▼2 (-50%) w/w ±0 (0%) ⚠\nSiteA : 2 d/d ▼2 (-50%) w/w ±0 (0%)\n",
+		},
+		{
+			name: "week",
+			args: args{period: aWeek, mapByChannel: map[string]int{"ABCDEF12345": 1}},
+			want: "2023-W01\n2023-01-02 - 2023-01-08\n1\n",
+		},
+		{
+			name: "month",
+			args: args{period: aMonth, mapByChannel: map[string]int{"ABCDEF12345": 1}},
+			want: "2023-02\nFebruary\n1\n",
+		},
+		{
+			name: "customWithDailyCounts",
+			args: args{period: someDays, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 4}}, mapByChannel: map[string]int{"ABCDEF12345": 4}, mapByDayByChannel: map[string]map[string]int{"ABCDEF12345": {"2023-01-01": 3, "2023-01-03": 1}}, channelMap: map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}},
+			want: "2023-01-01 - 2023-01-03\n4\n\n<#ABCDEF12345>\ndaily : 3 / 0 / 1\nSiteA : 4\n",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := &config{yesterDay: tt.args.yesterDay}
-			got := c.createMessage(tt.args.mapBySiteByChannel, tt.args.mapByChannel, tt.args.channelMap)
+			history, _ := loadHistory(tt.args.history)
+			c := &config{period: tt.args.period, history: history, trendThreshold: DEFAULT_TREND_THRESHOLD, anonymize: tt.args.anonymize}
+			got := createMessage(c.makeReport(result{countBySiteByChannel: tt.args.mapBySiteByChannel, countByHumanByChannel: tt.args.mapByHuman, countByEmojiByChannel: tt.args.mapByEmoji, reacted: tt.args.reacted, countByHourByChannel: tt.args.mapByHourByChannel, countByHostByChannel: tt.args.mapByHostByChannel, countByChannel: tt.args.mapByChannel, countByDayByChannel: tt.args.mapByDayByChannel, threads: tt.args.threads}, tt.args.channelMap))
 			if got != tt.want {
 				t.Errorf("createMessage() = \n%v, want \n%v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestCreateBlocks(t *testing.T) {
+	type args struct {
+		period             period
+		mapBySiteByChannel map[string]map[string]int
+		mapByChannel       map[string]int
+		mapByDayByChannel  map[string]map[string]int
+		threads            []thread
+		channelsByLink     map[string][]string
+		mapByEmoji         map[string]map[string]int
+		reacted            []reacted
+		channelMap         map[string]slack.Channel
+	}
+
+	aDay := period{name: "day", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}
+	someDays := period{name: "custom", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)}
+	channelMap := map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}
+
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "channelsIsNil",
+			args: args{period: aDay},
+			want: `[{"type":"header","text":{"type":"plain_text","text":"2023-01-01 Sunday : 0","emoji":true}},{"type":"context","elements":[{"type":"mrkdwn","text":"manage-slack/summary 2023-01-01 00:00 - 2023-01-02 00:00 UTC"}]}]`,
+		},
+		{
+			name: "channelsIsPresent",
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 1, "SiteB": 4}}, mapByChannel: map[string]int{"ABCDEF12345": 5}, channelMap: channelMap},
+			want: `[{"type":"header","text":{"type":"plain_text","text":"2023-01-01 Sunday : 5","emoji":true}},{"type":"divider"},{"type":"section","text":{"type":"mrkdwn","text":"*\u003c#ABCDEF12345\u003e* 5\n` + "`" + `██████████` + "`" + ` SiteB : 4\n` + "`" + `███░░░░░░░` + "`" + ` SiteA : 1\n"}},{"type":"context","elements":[{"type":"mrkdwn","text":"manage-slack/summary 2023-01-01 00:00 - 2023-01-02 00:00 UTC"}]}]`,
+		},
+		{
+			name: "multipleDaysWithThreads",
+			args: args{period: someDays, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 2}}, mapByChannel: map[string]int{"ABCDEF12345": 2}, mapByDayByChannel: map[string]map[string]int{"ABCDEF12345": {"2023-01-02": 2}}, threads: []thread{{channelID: "ABCDEF12345", replyCount: 1, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"}}, channelMap: channelMap},
+			want: `[{"type":"header","text":{"type":"plain_text","text":"2023-01-01 - 2023-01-02 : 2","emoji":true}},{"type":"divider"},{"type":"section","text":{"type":"mrkdwn","text":"*\u003c#ABCDEF12345\u003e* 2\ndaily : 0 / 2\n` + "`" + `██████████` + "`" + ` SiteA : 2\n"}},{"type":"divider"},{"type":"section","text":{"type":"mrkdwn","text":"*Most discussed threads*\n\u003c#ABCDEF12345\u003e : \u003chttps://example.slack.com/archives/ABCDEF12345/p1512085950000216|1 replies\u003e\n"}},{"type":"context","elements":[{"type":"mrkdwn","text":"manage-slack/summary 2023-01-01 00:00 - 2023-01-03 00:00 UTC"}]}]`,
+		},
+		{
+			name: "reactions",
+			args: args{period: aDay, mapBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 1}}, mapByChannel: map[string]int{"ABCDEF12345": 1}, mapByEmoji: map[string]map[string]int{"ABCDEF12345": {"eyes": 2}}, reacted: []reacted{{channelID: "ABCDEF12345", reactionCount: 2, permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"}}, channelMap: channelMap},
+			want: `[{"type":"header","text":{"type":"plain_text","text":"2023-01-01 Sunday : 1","emoji":true}},{"type":"divider"},{"type":"section","text":{"type":"mrkdwn","text":"*\u003c#ABCDEF12345\u003e* 1\n` + "`" + `██████████` + "`" + ` SiteA : 1\nreactions : 2\n"}},{"type":"divider"},{"type":"section","text":{"type":"mrkdwn","text":"*Most reacted messages*\n\u003c#ABCDEF12345\u003e : \u003chttps://example.slack.com/archives/ABCDEF12345/p1512085950000216|2 reactions\u003e\n"}},{"type":"divider"},{"type":"section","text":{"type":"mrkdwn","text":"*Top emoji*\n:eyes: 2\n"}},{"type":"context","elements":[{"type":"mrkdwn","text":"manage-slack/summary 2023-01-01 00:00 - 2023-01-02 00:00 UTC"}]}]`,
+		},
+		{
+			name: "duplicates",
+			args: args{period: aDay, channelsByLink: map[string][]string{"https://example.com/a": {"ABCDEF12345", "ABCDEF01234"}, "https://example.com/b": {"ABCDEF12345"}}},
+			want: `[{"type":"header","text":{"type":"plain_text","text":"2023-01-01 Sunday : 0","emoji":true}},{"type":"divider"},{"type":"section","text":{"type":"mrkdwn","text":"*Duplicate articles*\nhttps://example.com/a : posted in 2 channels \u003c#ABCDEF12345\u003e \u003c#ABCDEF01234\u003e\n"}},{"type":"context","elements":[{"type":"mrkdwn","text":"manage-slack/summary 2023-01-01 00:00 - 2023-01-02 00:00 UTC"}]}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config{period: tt.args.period}
+			blocks := createBlocks(c.makeReport(result{countBySiteByChannel: tt.args.mapBySiteByChannel, countByChannel: tt.args.mapByChannel, countByDayByChannel: tt.args.mapByDayByChannel, threads: tt.args.threads, channelsByLink: tt.args.channelsByLink, countByEmojiByChannel: tt.args.mapByEmoji, reacted: tt.args.reacted}, tt.args.channelMap))
+			got, err := json.Marshal(blocks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("createBlocks() = \n%v, want \n%v", string(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByCount(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  map[string]int
+		want []string
+	}{
+		{
+			name: "empty",
+			arg:  map[string]int{},
+			want: []string{},
+		},
+		{
+			name: "byCountThenKey",
+			arg:  map[string]int{"b": 1, "a": 1, "c": 3},
+			want: []string{"c", "a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortByCount(tt.arg)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("sortByCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateFile(t *testing.T) {
+	type want struct {
+		text   string
+		blocks string
+		err    string
+	}
+	report := Report{
+		Period: ReportPeriod{Name: "day", Title: []string{"2023-01-01", "Sunday"}},
+		Total:  3,
+		Channels: []ChannelReport{
+			{ID: "ABCDEF12345", Name: "channelName", Total: 3, Hosts: []Count{{Name: "example.com", Count: 2}, {Name: "example.org", Count: 1}}},
+		},
+		Links: []Count{{Name: "https://example.com/a", Count: 2}, {Name: "https://example.org/b", Count: 1}},
+	}
+	tests := []struct {
+		name string
+		path string
+		want want
+	}{
+		{
+			name: "text",
+			path: "testdata/templates/custom.tmpl",
+			want: want{text: "day 2023-01-01 total 3\n#channelName 3 example.com=2 example.org=1\nhttps://example.com/a\nhttps://example.org/b\n\n"},
+		},
+		{
+			name: "blocks",
+			path: "testdata/templates/blocks.json",
+			want: want{blocks: `[{"type":"header","text":{"type":"plain_text","text":"2023-01-01"}},{"type":"section","text":{"type":"mrkdwn","text":"\u003c#ABCDEF12345\u003e 3"}}]`},
+		},
+		{
+			name: "notBlocks",
+			path: "testdata/templates/notBlocks.json",
+			// the rest of the message depends on the encoding/json version
+			want: want{err: "can not decode blocks: json: cannot unmarshal number"},
+		},
+		{
+			name: "broken",
+			path: "testdata/templates/broken.tmpl",
+			want: want{err: "template: summary:2: unexpected EOF"},
+		},
+		{
+			name: "notExist",
+			path: "testdata/templates/notExist.tmpl",
+			want: want{err: "open testdata/templates/notExist.tmpl: no such file or directory"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, blocks, err := renderTemplateFile(tt.path, report)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if !strings.HasPrefix(gotErr, tt.want.err) || (gotErr != "") != (tt.want.err != "") {
+				t.Errorf("renderTemplateFile() err = \n%v, want \n%v", gotErr, tt.want.err)
+			}
+			if text != tt.want.text {
+				t.Errorf("renderTemplateFile() text = \n%v, want \n%v", text, tt.want.text)
+			}
+			gotBlocks := ""
+			if blocks != nil {
+				b, _ := json.Marshal(blocks)
+				gotBlocks = string(b)
+			}
+			if gotBlocks != tt.want.blocks {
+				t.Errorf("renderTemplateFile() blocks = \n%v, want \n%v", gotBlocks, tt.want.blocks)
+			}
+		})
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	type want struct {
+		countByDate map[string]int
+		err         string
+	}
+	tests := []struct {
+		name string
+		path string
+		want want
+	}{
+		{
+			name: "emptyPath",
+			path: "",
+			want: want{countByDate: map[string]int{}},
+		},
+		{
+			name: "notExist",
+			path: "testdata/history/notExist.jsonl",
+			want: want{countByDate: map[string]int{}},
+		},
+		{
+			name: "laterLineWins",
+			path: "testdata/history/twoDays.jsonl",
+			want: want{countByDate: map[string]int{"2022-12-25": 2, "2022-12-31": 4}},
+		},
+		{
+			name: "invalid",
+			path: "testdata/history/invalid.jsonl",
+			want: want{countByDate: map[string]int{"2022-12-31": 1}, err: "line 2: unexpected end of JSON input"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadHistory(tt.path)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("loadHistory() err = %v, want %v", gotErr, tt.want.err)
+			}
+			if len(got) != len(tt.want.countByDate) {
+				t.Errorf("loadHistory() = %v, want %v", got, tt.want.countByDate)
+			}
+			for date, count := range tt.want.countByDate {
+				if got[date].CountByChannel["ABCDEF12345"] != count {
+					t.Errorf("loadHistory()[%v] = %v, want %v", date, got[date], count)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendHistory(t *testing.T) {
+	path := t.TempDir() + "/history.jsonl"
+	c := &config{period: period{name: "day", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}}
+	r := result{countByChannel: map[string]int{"ABCDEF12345": 3}, countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 3}}, countByHostByChannel: map[string]map[string]int{"ABCDEF12345": {"example.com": 1}}}
+
+	for range 2 {
+		if err := appendHistory(path, c.makeHistoryRecord(r)); err != nil {
+			t.Fatalf("appendHistory() = %v", err)
+		}
+	}
+
+	b, _ := os.ReadFile(path)
+	line := `{"date":"2023-01-01","count_by_channel":{"ABCDEF12345":3},"count_by_author_by_channel":{"ABCDEF12345":{"SiteA":3}},"count_by_host_by_channel":{"ABCDEF12345":{"example.com":1}}}` + "\n"
+	if string(b) != line+line {
+		t.Errorf("appendHistory() wrote \n%v, want \n%v", string(b), line+line)
+	}
+}
+
+func TestFormatTrend(t *testing.T) {
+	tests := []struct {
+		name    string
+		trend   Trend
+		want    string
+		unusual bool
+	}{
+		{
+			name:  "unknown",
+			trend: makeTrend(3, 0, false),
+			want:  "",
+		},
+		{
+			name:    "up",
+			trend:   makeTrend(120, 100, true),
+			want:    "▲20 (+20%)",
+			unusual: false,
+		},
+		{
+			name:    "down",
+			trend:   makeTrend(25, 100, true),
+			want:    "▼75 (-75%)",
+			unusual: true,
+		},
+		{
+			name:    "new",
+			trend:   makeTrend(3, 0, true),
+			want:    "▲3 (new)",
+			unusual: true,
+		},
+		{
+			name:  "zero",
+			trend: makeTrend(0, 0, true),
+			want:  "±0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatTrend(tt.trend)
+			if got != tt.want {
+				t.Errorf("formatTrend() = %v, want %v", got, tt.want)
+			}
+			if isUnusual(tt.trend, DEFAULT_TREND_THRESHOLD) != tt.unusual {
+				t.Errorf("isUnusual() = %v, want %v", !tt.unusual, tt.unusual)
+			}
+		})
+	}
+}
+
+func TestRunHistoryCommand(t *testing.T) {
+	type want struct {
+		output string
+		err    string
+	}
+	tests := []struct {
+		name string
+		args []string
+		want want
+	}{
+		{
+			name: "channelTable",
+			args: []string{"-path", "testdata/history/withLinks.jsonl"},
+			want: want{output: "date        channel      count\n2023-01-01  ABCDEF12345  1\n2023-01-02  ABCDEF12345  3\n2023-01-02  ABCDEF01234  1\n"},
+		},
+		{
+			name: "authorCsvInRange",
+			args: []string{"-path", "testdata/history/withLinks.jsonl", "-by", "author", "-format", "csv", "-from", "2023-01-02", "-to", "2023-01-02"},
+			want: want{output: "date,channel,author,count\n2023-01-02,ABCDEF12345,SiteA,2\n2023-01-02,ABCDEF12345,SiteB,1\n"},
+		},
+		{
+			name: "linkCsv",
+			args: []string{"-path", "testdata/history/withLinks.jsonl", "-by", "link", "-format", "csv", "-to", "2023-01-01"},
+			want: want{output: "date,channel,link,count\n2023-01-01,ABCDEF12345,https://example.com/c,1\n"},
+		},
+		{
+			name: "noPath",
+			args: []string{"-path", ""},
+			want: want{err: "-path or SUMMARY_HISTORY_PATH is required"},
+		},
+		{
+			name: "invalidBy",
+			args: []string{"-path", "testdata/history/withLinks.jsonl", "-by", "day"},
+			want: want{err: "-by day is not one of channel, author, host or link"},
+		},
+		{
+			name: "invalidFormat",
+			args: []string{"-path", "testdata/history/withLinks.jsonl", "-format", "json"},
+			want: want{err: "-format json is not one of table or csv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := runHistoryCommand(tt.args, &buf)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("runHistoryCommand() err = %v, want %v", gotErr, tt.want.err)
+			}
+			if buf.String() != tt.want.output {
+				t.Errorf("runHistoryCommand() = \n%v, want \n%v", buf.String(), tt.want.output)
+			}
+		})
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{
+			name:    "textWithLinks",
+			message: "testdata/messages/textWithLinks.json",
+			want:    []string{"https://example.com/a?x=1&y=2", "https://example.org/b"},
+		},
+		{
+			name:    "attachments",
+			message: "testdata/messages/attachments.json",
+			want:    []string{"https://example.com/article", "https://feeds.example.com/rss", "https://example.net/other"},
+		},
+		{
+			name:    "richTextBlocks",
+			message: "testdata/messages/richTextBlocks.json",
+			want:    []string{"https://example.com/rich", "https://example.org/listed", "https://example.net/quoted", "https://example.com/section", "https://example.com/field"},
+		},
+		{
+			name:    "noLinks",
+			message: "testdata/messages/noLinks.json",
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := testdata.ReadFile(tt.message)
+			var message slack.Message
+			if err := json.Unmarshal(b, &message); err != nil {
+				t.Fatal(err)
+			}
+			got := extractLinks(message)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("extractLinks() = \n%v, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeLink(t *testing.T) {
+	shorteners, err := loadShorteners("testdata/shorteners/shorteners.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{name: "canonical", link: "https://example.com/a", want: "https://example.com/a"},
+		{name: "trackingParams", link: "https://example.com/a?utm_source=rss&UTM_Medium=feed&id=1&fbclid=abc", want: "https://example.com/a?id=1"},
+		{name: "sortedQuery", link: "https://example.com/a?b=2&a=1", want: "https://example.com/a?a=1&b=2"},
+		{name: "fragment", link: "https://example.com/a#comments", want: "https://example.com/a"},
+		{name: "schemeAndHost", link: "HTTP://Example.COM:80/A", want: "https://example.com/A"},
+		{name: "rootPath", link: "https://example.com/?utm_source=rss", want: "https://example.com"},
+		{name: "shortener", link: "https://bit.ly/abc?utm_source=rss", want: "https://example.com/article"},
+		{name: "chainedShorteners", link: "https://t.co/xyz", want: "https://example.com/article"},
+		{name: "loop", link: "https://t.co/loop", want: "https://t.co/loop"},
+		{name: "notURL", link: "example.com/a", want: "example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalizeLink(tt.link, shorteners); got != tt.want {
+				t.Errorf("canonicalizeLink() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadShorteners(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want int
+		err  bool
+	}{
+		{name: "empty", path: "", want: 0},
+		{name: "shorteners", path: "testdata/shorteners/shorteners.json", want: 4},
+		{name: "notFound", path: "testdata/shorteners/notFound.json", want: 0, err: true},
+		{name: "invalid", path: "testdata/history/invalid.jsonl", want: 0, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadShorteners(tt.path)
+			if (err != nil) != tt.err {
+				t.Errorf("loadShorteners() error = %v, want error %v", err, tt.err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("loadShorteners() = %v, want %v entries", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueCountByHostByChannel(t *testing.T) {
+	r := result{
+		countByHostByChannel: map[string]map[string]int{"ABCDEF01234": {"example.com": 3, "example.org": 1}, "ABCDEF12345": {"example.com": 1}},
+		countByLinkByChannel: map[string]map[string]int{"ABCDEF01234": {"https://example.com/a": 2, "https://example.com/b": 1, "https://example.org/c": 1}, "ABCDEF12345": {"https://example.com/a": 1}},
+		channelsByLink:       map[string][]string{"https://example.com/a": {"ABCDEF01234", "ABCDEF12345"}, "https://example.com/b": {"ABCDEF01234"}, "https://example.org/c": {"ABCDEF01234"}},
+	}
+	c := &config{}
+	got := c.uniqueCountByHostByChannel(r)
+	want := map[string]map[string]int{"ABCDEF01234": {"example.com": 2, "example.org": 1}, "ABCDEF12345": {}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("uniqueCountByHostByChannel() = %v, want %v", got, want)
+	}
+	if r.countByHostByChannel["ABCDEF01234"]["example.com"] != 3 {
+		t.Errorf("uniqueCountByHostByChannel() changed the counts of the result %v", r.countByHostByChannel)
+	}
+}
+
+func TestSiteOf(t *testing.T) {
+	siteByHost, err := loadSiteAliases("testdata/siteAliases/aliases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		foldSubdomains bool
+		host           string
+		want           string
+	}{
+		{name: "empty", host: "", want: ""},
+		{name: "host", host: "feeds.example.org", want: "feeds.example.org"},
+		{name: "www", host: "WWW.Example.org:443", want: "example.org"},
+		{name: "folded", foldSubdomains: true, host: "feeds.example.org", want: "example.org"},
+		{name: "foldedPublicSuffix", foldSubdomains: true, host: "news.example.co.jp", want: "example.co.jp"},
+		{name: "foldedPrivateSuffix", foldSubdomains: true, host: "someone.github.io", want: "someone.github.io"},
+		{name: "hostAlias", host: "blog.example.com", want: "Example Blog"},
+		{name: "hostAliasWinsOverDomainAlias", foldSubdomains: true, host: "blog.example.com", want: "Example Blog"},
+		{name: "domainAlias", host: "feeds.example.com", want: "Example"},
+		{name: "publicSuffix", foldSubdomains: true, host: "localhost", want: "localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config{foldSubdomains: tt.foldSubdomains, siteByHost: siteByHost}
+			if got := c.siteOf(tt.host); got != tt.want {
+				t.Errorf("siteOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeAttribute(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "example.com", want: "example_com"},
+		{value: "channel-name", want: "channel_name"},
+		{value: "Example Blog", want: "Example_Blog"},
+		{value: "ニュース", want: "ニュース"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := sanitizeAttribute(tt.value); got != tt.want {
+				t.Errorf("sanitizeAttribute() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserCacheLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		id       string
+		usersRes string
+		infoRes  string
+		want     CachedUser
+		wantOk   bool
+		calls    string
+		err      string
+	}{
+		{name: "fresh", ttl: 100 * 365 * 24 * time.Hour, id: "U0123456789", want: CachedUser{Name: "alice.cached"}, wantOk: true, calls: ""},
+		{name: "staleInList", ttl: time.Hour, id: "U0123456789", usersRes: "testdata/usersList/ok.json", want: CachedUser{Name: "alice.d"}, wantOk: true, calls: "/users.list"},
+		{name: "realName", ttl: time.Hour, id: "U1234567890", usersRes: "testdata/usersList/ok.json", want: CachedUser{Name: "Bob"}, wantOk: true, calls: "/users.list"},
+		{name: "bot", ttl: time.Hour, id: "U2345678901", usersRes: "testdata/usersList/ok.json", want: CachedUser{Name: "deploy-bot", Bot: true}, wantOk: true, calls: "/users.list"},
+		{name: "notInList", ttl: time.Hour, id: "U3456789012", usersRes: "testdata/usersList/ok.json", infoRes: "testdata/usersInfo/ok.json", want: CachedUser{Name: "carol.c"}, wantOk: true, calls: "/users.list /users.info"},
+		{name: "listError", ttl: time.Hour, id: "U3456789012", usersRes: "testdata/usersList/error.json", infoRes: "testdata/usersInfo/ok.json", want: CachedUser{Name: "carol.c"}, wantOk: true, calls: "/users.list /users.info", err: "can not get users: missing_scope"},
+		{name: "staleFallback", ttl: time.Hour, id: "U9999999999", usersRes: "testdata/usersList/ok.json", infoRes: "testdata/usersInfo/error.json", want: CachedUser{Name: "dave.cached"}, wantOk: true, calls: "/users.list /users.info", err: "can not get user userID: U9999999999 user_not_found"},
+		{name: "unknown", ttl: time.Hour, id: "U8888888888", usersRes: "testdata/usersList/ok.json", infoRes: "testdata/usersInfo/error.json", wantOk: false, calls: "/users.list /users.info", err: "can not get user userID: U8888888888 user_not_found"},
+		{name: "empty", ttl: time.Hour, id: "", wantOk: false, calls: ""},
+	}
+	for _, tt := range tests {
+		calls := []string{}
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/users.list", func(w http.ResponseWriter, _ *http.Request) {
+				calls = append(calls, "/users.list")
+				res, _ := testdata.ReadFile(tt.usersRes)
+				w.Write(res)
+			})
+			c.Handle("/users.info", func(w http.ResponseWriter, _ *http.Request) {
+				calls = append(calls, "/users.info")
+				res, _ := testdata.ReadFile(tt.infoRes)
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defaultFlags := log.Flags()
+			log.SetFlags(0)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(defaultFlags)
+			}()
+
+			cache, err := loadUserCache(client, "testdata/userCache/cache.json", tt.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := cache.lookup(tt.id)
+			if ok != tt.wantOk || got.Name != tt.want.Name || got.Bot != tt.want.Bot {
+				t.Errorf("lookup() = %v %v, want %v %v", got, ok, tt.want, tt.wantOk)
+			}
+			if strings.Join(calls, " ") != tt.calls {
+				t.Errorf("lookup() calls = %v, want %v", calls, tt.calls)
+			}
+			if gotPrint := strings.TrimRight(buf.String(), "\n"); gotPrint != tt.err {
+				t.Errorf("lookup() = \n%v, want \n%v", gotPrint, tt.err)
+			}
+		})
+	}
+}
+
+func TestUserCacheSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	cache, err := loadUserCache(nil, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("save() wrote an unchanged cache: %v", err)
+	}
+	cache.put(slack.User{ID: "U0123456789", Name: "alice"})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadUserCache(nil, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := loaded.fresh("U0123456789"); !ok || user.Name != "alice" {
+		t.Errorf("loadUserCache() = %v %v, want alice", user, ok)
+	}
+}
+
+func TestHours(t *testing.T) {
+	tests := []struct {
+		name        string
+		countByHour []int
+		want        string
+	}{
+		{name: "nil", countByHour: nil, want: ""},
+		{name: "zero", countByHour: make([]int, 24), want: ""},
+		{name: "levels", countByHour: []int{8, 1, 2, 3, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 16}, want: "`▄▁▁▂▂▃▃▄               █` peak 23:00"},
+		{name: "peakIsTheFirstBusiestHour", countByHour: []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3}, want: "`         █             █` peak 09:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hours(tt.countByHour); got != tt.want {
+				t.Errorf("hours() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBarChart(t *testing.T) {
+	b, err := barChart("Messages by channel", []Count{{Name: "#general", Count: 4}, {Name: "#random", Count: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != CHART_WIDTH || img.Bounds().Dy() != 40+2*CHART_ROW_HEIGHT {
+		t.Errorf("barChart() bounds = %v", img.Bounds())
+	}
+	left := 10 + CHART_LABEL_SIZE*7 + 10
+	full := CHART_WIDTH - 60
+	half := left + (full-left)/2
+	rows := []struct {
+		x, y int
+		want color.Color
+	}{
+		{x: full - 1, y: 50, want: chartBar},
+		{x: half - 1, y: 70, want: chartBar},
+		{x: half + 1, y: 70, want: chartBackground},
+	}
+	for _, row := range rows {
+		if got := color.RGBAModel.Convert(img.At(row.x, row.y)); got != row.want {
+			t.Errorf("barChart() at (%v, %v) = %v, want %v", row.x, row.y, got, row.want)
+		}
+	}
+}
+
+func TestLineChart(t *testing.T) {
+	b, err := lineChart("Messages in the last 14 days", []string{"2023-01-01", "2023-01-02"}, []int{0, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != CHART_WIDTH {
+		t.Errorf("lineChart() bounds = %v", img.Bounds())
+	}
+	// the points are at the bottom left and the top right of the plot
+	for _, p := range []image.Point{{X: 50, Y: 270}, {X: CHART_WIDTH - 20, Y: 40}} {
+		if got := color.RGBAModel.Convert(img.At(p.X, p.Y)); got != chartBar {
+			t.Errorf("lineChart() at %v = %v, want %v", p, got, chartBar)
+		}
+	}
+}
+
+func TestTrendSeries(t *testing.T) {
+	history, err := loadHistory("testdata/history/twoDays.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &config{period: period{name: "day", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}, history: history}
+	labels, values := c.trendSeries(result{countByDayByChannel: map[string]map[string]int{"ABCDEF12345": {"2023-01-01": 2}, "ABCDEF01234": {"2023-01-01": 1}}})
+	if len(labels) != TREND_DAYS || labels[0] != "2022-12-19" || labels[TREND_DAYS-1] != "2023-01-01" {
+		t.Errorf("trendSeries() labels = %v", labels)
+	}
+	want := []int{0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 4, 3}
+	if fmt.Sprint(values) != fmt.Sprint(want) {
+		t.Errorf("trendSeries() values = %v, want %v", values, want)
+	}
+}
+
+// a day in the period counts its replies as the history store does, so the trend does not drop at the last day
+func TestTrendSeriesWithReplies(t *testing.T) {
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/conversations.history", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/conversationsHistory/thread.json")
+			w.Write(res)
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+	users := &userCache{ttl: time.Hour, listed: true, users: map[string]CachedUser{}}
+	c := &config{userClient: client, period: period{name: "day", from: time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC)}, users: users, history: map[string]HistoryRecord{}}
+	r := c.makeResult([]slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}})
+	_, values := c.trendSeries(r)
+	if values[TREND_DAYS-1] != 3 {
+		t.Errorf("trendSeries() today = %v, want 3", values[TREND_DAYS-1])
+	}
+
+	c.history["2017-12-01"] = c.makeHistoryRecord(r)
+	c.period = period{name: "day", from: time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC), to: time.Date(2017, 12, 3, 0, 0, 0, 0, time.UTC)}
+	_, values = c.trendSeries(result{countByDayByChannel: map[string]map[string]int{}})
+	if values[TREND_DAYS-2] != 3 {
+		t.Errorf("trendSeries() yesterday = %v, want 3", values[TREND_DAYS-2])
+	}
+}
+
+func TestUploadCharts(t *testing.T) {
+	tests := []struct {
+		name        string
+		completeRes string
+		want        int
+		err         string
+	}{
+		{name: "ok", completeRes: "testdata/filesCompleteUploadExternal/ok.json", want: 3},
+		{name: "error", completeRes: "testdata/filesCompleteUploadExternal/error.json", want: 3, err: "can not upload chart: channels.png CompleteUploadExternal: not_in_channel\ncan not upload chart: sites.png CompleteUploadExternal: not_in_channel\ncan not upload chart: trend.png CompleteUploadExternal: not_in_channel"},
+	}
+	for _, tt := range tests {
+		threads := []string{}
+		var ts *slacktest.Server
+		ts = slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/files.getUploadURLExternal", func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprintf(w, `{"ok":true,"upload_url":"%supload","file_id":"F0123456789"}`, ts.GetAPIURL())
+			})
+			c.Handle("/upload", func(w http.ResponseWriter, _ *http.Request) {
+				w.Write([]byte("OK"))
+			})
+			c.Handle("/files.completeUploadExternal", func(w http.ResponseWriter, r *http.Request) {
+				threads = append(threads, r.FormValue("channel_id")+"/"+r.FormValue("thread_ts"))
+				res, _ := testdata.ReadFile(tt.completeRes)
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defaultFlags := log.Flags()
+			log.SetFlags(0)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(defaultFlags)
+			}()
+
+			c := &config{period: period{name: "day", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}}
+			channelMap := map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}
+			r := result{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 1}}, countByChannel: map[string]int{"ABCDEF12345": 1}}
+			c.uploadCharts(client, "C0123456789", "1512085950.000216", c.makeReport(r, channelMap), r)
+			if len(threads) != tt.want {
+				t.Errorf("uploadCharts() uploaded %v, want %v", threads, tt.want)
+			}
+			for _, thread := range threads {
+				if thread != "C0123456789/1512085950.000216" {
+					t.Errorf("uploadCharts() replied to %v", thread)
+				}
+			}
+			if gotPrint := strings.TrimRight(buf.String(), "\n"); gotPrint != tt.err {
+				t.Errorf("uploadCharts() = \n%v, want \n%v", gotPrint, tt.err)
+			}
+		})
+	}
+}
+
+func TestFeedHealth(t *testing.T) {
+	aDay := period{name: "day", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}
+	today := result{countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"FeedA": 9, "FeedC": 1}}}
+	tests := []struct {
+		name      string
+		period    period
+		history   string
+		stallDays int
+		want      []feed
+	}{
+		{
+			name:      "stalled",
+			period:    aDay,
+			history:   "testdata/history/feeds.jsonl",
+			stallDays: 3,
+			want: []feed{
+				{channelID: "ABCDEF12345", author: "FeedA", baseline: 10, recent: []int{10, 10, 9}},
+				{channelID: "ABCDEF12345", author: "FeedB", baseline: 5, recent: []int{0, 0, 0}, stalled: true},
+				{channelID: "ABCDEF12345", author: "FeedC", baseline: 10, recent: []int{1, 1, 1}, stalled: true},
+				{channelID: "ABCDEF12345", author: "FeedD", baseline: 2.0 / 12, recent: []int{0, 0, 0}},
+			},
+		},
+		{
+			name:      "recoveredInTheWindow",
+			period:    aDay,
+			history:   "testdata/history/feeds.jsonl",
+			stallDays: 4,
+			want: []feed{
+				{channelID: "ABCDEF12345", author: "FeedA", baseline: 10, recent: []int{10, 10, 10, 9}},
+				{channelID: "ABCDEF12345", author: "FeedB", baseline: 5, recent: []int{5, 0, 0, 0}},
+				{channelID: "ABCDEF12345", author: "FeedC", baseline: 10, recent: []int{10, 1, 1, 1}},
+				{channelID: "ABCDEF12345", author: "FeedD", baseline: 2.0 / 11, recent: []int{0, 0, 0, 0}},
+			},
+		},
+		{
+			name:      "notADay",
+			period:    period{name: "custom", from: aDay.from, to: aDay.to},
+			history:   "testdata/history/feeds.jsonl",
+			stallDays: 3,
+		},
+		{
+			name:      "recentDayIsNotRecorded",
+			period:    aDay,
+			history:   "testdata/history/twoDays.jsonl",
+			stallDays: 3,
+		},
+		{
+			name:      "shortBaseline",
+			period:    aDay,
+			history:   "testdata/history/twoDays.jsonl",
+			stallDays: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := loadHistory(tt.history)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &config{period: tt.period, history: history, stallDays: tt.stallDays, stallRatio: DEFAULT_STALL_RATIO}
+			got := c.feedHealth(today)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("feedHealth() = \n%v, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateStalledMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		feeds []feed
+		want  string
+	}{
+		{name: "nil", feeds: nil, want: ""},
+		{name: "healthy", feeds: []feed{{channelID: "ABCDEF12345", author: "FeedA", baseline: 10, recent: []int{10, 10, 9}}}, want: ""},
+		{
+			name:  "stalled",
+			feeds: []feed{{channelID: "ABCDEF12345", author: "FeedA", baseline: 10, recent: []int{10, 10, 9}}, {channelID: "ABCDEF12345", author: "FeedB", baseline: 5.25, recent: []int{0, 0, 0}, stalled: true}},
+			want:  "Stalled feeds\n<#ABCDEF12345> FeedB : 0 / 0 / 0 (baseline 5.2/day)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createStalledMessage(tt.feeds); got != tt.want {
+				t.Errorf("createStalledMessage() = \n%v, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindInactiveChannels(t *testing.T) {
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/conversations.history", func(w http.ResponseWriter, r *http.Request) {
+			apiResByChannel := map[string]string{
+				"ABCDEF01234": "testdata/conversationsHistory/aMessage.json",
+				"ABCDEF12345": "testdata/conversationsHistory/empty.json",
+				"ABCDEF23456": "testdata/conversationsHistory/error.json",
+				"ABCDEF34567": "testdata/conversationsHistory/empty.json",
+				"GENERAL0001": "testdata/conversationsHistory/empty.json",
+			}
+			res, _ := testdata.ReadFile(apiResByChannel[r.FormValue("channel")])
+			w.Write(res)
+		})
+		c.Handle("/conversations.info", func(w http.ResponseWriter, r *http.Request) {
+			apiResByChannel := map[string]string{
+				"ABCDEF12345": "testdata/conversationsInfo/ok.json",
+				"ABCDEF34567": "testdata/conversationsHistory/error.json",
+				"GENERAL0001": "testdata/conversationsInfo/general.json",
+			}
+			res, _ := testdata.ReadFile(apiResByChannel[r.FormValue("channel")])
+			w.Write(res)
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defaultFlags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(defaultFlags)
+	}()
+
+	conversations := []slack.Channel{}
+	for _, id := range []string{"ABCDEF01234", "ABCDEF12345", "ABCDEF23456", "ABCDEF34567", "GENERAL0001"} {
+		conversations = append(conversations, slack.Channel{GroupConversation: slack.GroupConversation{Name: "name-" + id, Conversation: slack.Conversation{ID: id}}})
+	}
+	c := &config{userClient: client, inactive: inactivePolicy{days: 5}}
+	got := c.findInactiveChannels(conversations, time.Date(2017, 12, 5, 0, 0, 0, 0, time.UTC))
+	want := []inactiveChannel{
+		{channelID: "ABCDEF12345", name: "old-project", members: 3, checked: true},
+		{channelID: "ABCDEF34567", name: "name-ABCDEF34567"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("findInactiveChannels() = \n%v, want \n%v", got, want)
+	}
+	wantPrint := "can not get last activity channelID: ABCDEF23456 channel_not_found\ncan not get channel info channelID: ABCDEF34567 channel_not_found"
+	if gotPrint := strings.TrimRight(buf.String(), "\n"); gotPrint != wantPrint {
+		t.Errorf("findInactiveChannels() = \n%v, want \n%v", gotPrint, wantPrint)
+	}
+}
+
+func TestApplyInactivePolicy(t *testing.T) {
+	lastActivity := time.Date(2017, 11, 30, 0, 0, 0, 0, time.UTC)
+	channels := func() []inactiveChannel {
+		return []inactiveChannel{
+			{channelID: "ABCDEF01234", name: "small", members: 2, checked: true, lastActivity: lastActivity},
+			{channelID: "ABCDEF12345", name: "large", members: 50, checked: true},
+			{channelID: "REPORT00001", name: "report", members: 1, checked: true},
+			// the info of this channel could not be fetched, so no action is taken on it
+			{channelID: "ABCDEF34567", name: "unknown"},
+		}
+	}
+	tests := []struct {
+		name       string
+		policy     inactivePolicy
+		archiveRes string
+		want       string
+		calls      string
+		err        string
+	}{
+		{
+			name:   "noAction",
+			policy: inactivePolicy{days: 90},
+			want:   "Inactive channels, no messages in 90 days\n<#ABCDEF01234> 2 members, last activity 2017-11-30\n<#ABCDEF12345> 50 members, last activity never\n<#REPORT00001> 1 members, last activity never\n<#ABCDEF34567> members unknown, last activity never\n",
+			calls:  "",
+		},
+		{
+			name:   "dryRun",
+			policy: inactivePolicy{days: 90, action: "archive", maxMembers: 10},
+			want:   "Inactive channels, no messages in 90 days (dry run)\n<#ABCDEF01234> 2 members, last activity 2017-11-30, would archive\n<#ABCDEF12345> 50 members, last activity never\n<#REPORT00001> 1 members, last activity never\n<#ABCDEF34567> members unknown, last activity never\n",
+			calls:  "",
+		},
+		{
+			name:       "archive",
+			policy:     inactivePolicy{days: 90, action: "archive", maxMembers: 10, apply: true},
+			archiveRes: "testdata/conversationsArchive/ok.json",
+			want:       "Inactive channels, no messages in 90 days\n<#ABCDEF01234> 2 members, last activity 2017-11-30, archived\n<#ABCDEF12345> 50 members, last activity never\n<#REPORT00001> 1 members, last activity never\n<#ABCDEF34567> members unknown, last activity never\n",
+			calls:      "/conversations.archive ABCDEF01234",
+		},
+		{
+			name:       "archiveError",
+			policy:     inactivePolicy{days: 90, action: "archive", maxMembers: 10, apply: true},
+			archiveRes: "testdata/conversationsArchive/error.json",
+			want:       "Inactive channels, no messages in 90 days\n<#ABCDEF01234> 2 members, last activity 2017-11-30, would archive\n<#ABCDEF12345> 50 members, last activity never\n<#REPORT00001> 1 members, last activity never\n<#ABCDEF34567> members unknown, last activity never\n",
+			calls:      "/conversations.archive ABCDEF01234",
+			err:        "can not archive channelID: ABCDEF01234 not_authorized",
+		},
+		{
+			name:   "leaveEveryChannel",
+			policy: inactivePolicy{days: 90, action: "leave", apply: true},
+			want:   "Inactive channels, no messages in 90 days\n<#ABCDEF01234> 2 members, last activity 2017-11-30, left\n<#ABCDEF12345> 50 members, last activity never, left\n<#REPORT00001> 1 members, last activity never\n<#ABCDEF34567> members unknown, last activity never\n",
+			calls:  "/conversations.leave ABCDEF01234 /conversations.leave ABCDEF12345",
+		},
+	}
+	for _, tt := range tests {
+		calls := []string{}
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/conversations.archive", func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "/conversations.archive "+r.FormValue("channel"))
+				res, _ := testdata.ReadFile(tt.archiveRes)
+				w.Write(res)
+			})
+			c.Handle("/conversations.leave", func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "/conversations.leave "+r.FormValue("channel"))
+				res, _ := testdata.ReadFile("testdata/conversationsLeave/ok.json")
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defaultFlags := log.Flags()
+			log.SetFlags(0)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(defaultFlags)
+			}()
+
+			c := &config{userClient: client, period: period{from: time.Date(2017, 12, 4, 0, 0, 0, 0, time.UTC)}, inactive: tt.policy}
+			got := c.createInactiveMessage(c.applyInactivePolicy(channels(), "REPORT00001"))
+			if got != tt.want {
+				t.Errorf("createInactiveMessage() = \n%v, want \n%v", got, tt.want)
+			}
+			if strings.Join(calls, " ") != tt.calls {
+				t.Errorf("applyInactivePolicy() calls = %v, want %v", calls, tt.calls)
+			}
+			if gotPrint := strings.TrimRight(buf.String(), "\n"); gotPrint != tt.err {
+				t.Errorf("applyInactivePolicy() = \n%v, want \n%v", gotPrint, tt.err)
+			}
+		})
+	}
+}
+
+func TestExtractArticles(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []article
+	}{
+		{
+			name:    "textWithLinks",
+			message: "testdata/messages/textWithLinks.json",
+			want:    []article{{link: "https://example.com/a?x=1&y=2", title: "first"}, {link: "https://example.org/b"}},
+		},
+		{
+			name:    "attachments",
+			message: "testdata/messages/attachments.json",
+			want:    []article{{link: "https://example.com/article", title: "An article"}, {link: "https://feeds.example.com/rss", title: "feed"}, {link: "https://example.net/other", title: "other"}},
+		},
+		{
+			name:    "richTextBlocks",
+			message: "testdata/messages/richTextBlocks.json",
+			want:    []article{{link: "https://example.com/rich"}, {link: "https://example.org/listed", title: "listed"}, {link: "https://example.net/quoted"}, {link: "https://example.com/section", title: "section"}, {link: "https://example.com/field"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := testdata.ReadFile(tt.message)
+			var message slack.Message
+			if err := json.Unmarshal(b, &message); err != nil {
+				t.Fatal(err)
+			}
+			if got := extractArticles(message); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("extractArticles() = \n%v, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateDigest(t *testing.T) {
+	many := []article{}
+	for i := 0; i < MAX_DIGEST_ARTICLES+2; i++ {
+		many = append(many, article{link: "https://example.com/" + strconv.Itoa(i), site: "example.com"})
+	}
+	tests := []struct {
+		name     string
+		articles []article
+		want     string
+	}{
+		{name: "empty", articles: []article{}, want: ""},
+		{
+			name:     "groupedBySite",
+			articles: []article{{link: "https://example.org/a", title: "A <b> & c|d", site: "example.org"}, {link: "https://example.com/b", title: "B", site: "Example"}, {link: "https://example.com/c", site: "Example"}},
+			want:     "*Articles in <#ABCDEF12345>*\n*Example*\n• <https://example.com/b|B>\n• <https://example.com/c>\n*example.org*\n• <https://example.org/a|A &lt;b&gt; &amp; c¦d>\n",
+		},
+		{
+			name:     "truncated",
+			articles: many,
+			want:     "*Articles in <#ABCDEF12345>*\n*example.com*\n• <https://example.com/0>\n• <https://example.com/1>\n• <https://example.com/2>\n• <https://example.com/3>\n• <https://example.com/4>\n• <https://example.com/5>\n• <https://example.com/6>\n• <https://example.com/7>\n• <https://example.com/8>\n• <https://example.com/9>\nand 2 more\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createDigest("ABCDEF12345", tt.articles); got != tt.want {
+				t.Errorf("createDigest() = \n%v, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostDigests(t *testing.T) {
+	posts := []string{}
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+			posts = append(posts, r.FormValue("channel")+"/"+r.FormValue("thread_ts")+" "+r.FormValue("text"))
+			w.Write([]byte(`{"ok":true,"channel":"C0123456789","ts":"1512085960.000216"}`))
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+
+	channelMap := map[string]slack.Channel{
+		"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}},
+		"ABCDEF01234": {GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}},
+	}
+	r := result{
+		countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 1}, "ABCDEF01234": {"SiteA": 2}},
+		countByChannel:       map[string]int{"ABCDEF12345": 1, "ABCDEF01234": 2},
+		articlesByChannel:    map[string][]article{"ABCDEF12345": {{link: "https://example.com/a", title: "A", site: "example.com"}}},
+	}
+	postDigests(client, "C0123456789", "1512085950.000216", r, channelMap)
+	want := []string{"C0123456789/1512085950.000216 *Articles in <#ABCDEF12345>*\n*example.com*\n• <https://example.com/a|A>\n"}
+	if fmt.Sprint(posts) != fmt.Sprint(want) {
+		t.Errorf("postDigests() = %v, want %v", posts, want)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{name: "short", text: "a\nb\n", limit: 10, want: []string{"a\nb\n"}},
+		{name: "lines", text: "aaa\nbbb\nccc\n", limit: 8, want: []string{"aaa\nbbb\n", "ccc\n"}},
+		{name: "longLine", text: "a\nbbbbbbbbbb\nc", limit: 4, want: []string{"a\n", "bbbb", "bbbb", "bb\nc"}},
+		{name: "runes", text: "あいう", limit: 4, want: []string{"あ", "い", "う"}},
+		{name: "limitIsSmallerThanARune", text: "あい", limit: 1, want: []string{"あ", "い"}},
+		{name: "noLimit", text: "abc", limit: 0, want: []string{"abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitLines(tt.text, tt.limit)
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("splitLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostReport(t *testing.T) {
+	channelMap := map[string]slack.Channel{
+		"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}},
+		"ABCDEF01234": {GroupConversation: slack.GroupConversation{Name: "channelNameA", Conversation: slack.Conversation{ID: "ABCDEF01234"}}},
+		"ABCDEF23456": {GroupConversation: slack.GroupConversation{Name: "channelNameB", Conversation: slack.Conversation{ID: "ABCDEF23456"}}},
+	}
+	r := result{
+		countBySiteByChannel: map[string]map[string]int{"ABCDEF12345": {"SiteA": 1}, "ABCDEF01234": {"SiteA": 2, "SiteB": 1}, "ABCDEF23456": {"SiteC": 4}},
+		countByChannel:       map[string]int{"ABCDEF12345": 1, "ABCDEF01234": 3, "ABCDEF23456": 4},
+	}
+	tests := []struct {
+		name     string
+		split    bool
+		perReply int
+		// maxBlocks makes chat.postMessage answer msg_too_long for a message with more blocks
+		maxBlocks int
+		// templated posts text or sections blocks as the output of a custom template
+		templated bool
+		text      string
+		sections  int
+		want      []string
+		err       string
+	}{
+		{
+			name:      "aMessage",
+			maxBlocks: MAX_BLOCKS,
+			want:      []string{"/ 8 blocks"},
+		},
+		{
+			name:      "split",
+			split:     true,
+			perReply:  1,
+			maxBlocks: MAX_BLOCKS,
+			want:      []string{"/ 3 blocks", "1512085950.000216/ <#ABCDEF23456>", "1512085950.000216/ <#ABCDEF01234>", "1512085950.000216/ <#ABCDEF12345>"},
+		},
+		{
+			name:      "splitByTwoChannels",
+			split:     true,
+			perReply:  2,
+			maxBlocks: MAX_BLOCKS,
+			want:      []string{"/ 3 blocks", "1512085950.000216/ <#ABCDEF23456> <#ABCDEF01234>", "1512085950.000216/ <#ABCDEF12345>"},
+		},
+		{
+			name:      "msgTooLong",
+			perReply:  3,
+			maxBlocks: 5,
+			want:      []string{"/ 3 blocks", "1512085950.000216/ <#ABCDEF23456> <#ABCDEF01234> <#ABCDEF12345>"},
+			err:       "the report is too long, split it: msg_too_long",
+		},
+		{
+			name:      "overviewTooLong",
+			split:     true,
+			perReply:  3,
+			maxBlocks: 2,
+			want:      []string{"/ 1 blocks", "1512085950.000216/ 2 blocks", "1512085950.000216/ <#ABCDEF23456> <#ABCDEF01234> <#ABCDEF12345>"},
+		},
+		{
+			name:      "templatedText",
+			split:     true,
+			maxBlocks: MAX_BLOCKS,
+			templated: true,
+			text:      "<#ABCDEF12345> " + strings.Repeat("a", 2500) + "\n<#ABCDEF01234> " + strings.Repeat("a", 2500) + "\n",
+			want:      []string{"/ <#ABCDEF12345>", "1512085950.000216/ <#ABCDEF01234>"},
+		},
+		{
+			name:      "templatedTextNotSplit",
+			maxBlocks: MAX_BLOCKS,
+			templated: true,
+			text:      "<#ABCDEF12345> " + strings.Repeat("a", 2500) + "\n<#ABCDEF01234> " + strings.Repeat("a", 2500) + "\n",
+			want:      []string{"/ <#ABCDEF12345> <#ABCDEF01234>"},
+		},
+		{
+			name:      "templatedBlocks",
+			split:     true,
+			maxBlocks: MAX_BLOCKS,
+			templated: true,
+			sections:  MAX_BLOCKS + 10,
+			want:      []string{"/ 50 blocks", "1512085950.000216/ 10 blocks"},
+		},
+	}
+	for _, tt := range tests {
+		posts := []string{}
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+				text := r.FormValue("text")
+				post := r.FormValue("thread_ts") + "/"
+				if blocks := r.FormValue("blocks"); blocks != "" {
+					var b slack.Blocks
+					json.Unmarshal([]byte(blocks), &b)
+					if len(b.BlockSet) > tt.maxBlocks {
+						w.Write([]byte(`{"ok":false,"error":"msg_too_long"}`))
+						return
+					}
+					post += " " + strconv.Itoa(len(b.BlockSet)) + " blocks"
+				} else {
+					for _, match := range regexp.MustCompile(`<#[A-Z0-9]+>`).FindAllString(text, -1) {
+						post += " " + match
+					}
+				}
+				posts = append(posts, post)
+				w.Write([]byte(`{"ok":true,"channel":"C0123456789","ts":"1512085950.000216"}`))
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defaultFlags := log.Flags()
+			log.SetFlags(0)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(defaultFlags)
+			}()
+
+			c := &config{period: period{name: "day", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}}
+			report := c.makeReport(r, channelMap)
+			message := createMessage(report)
+			blocks := createBlocks(report)
+			if tt.templated {
+				message, blocks = tt.text, nil
+				for range tt.sections {
+					blocks = append(blocks, markdownSection("section"))
+				}
+			}
+			got, err := postReport(client, "C0123456789", message, blocks, report, tt.split, tt.perReply, tt.templated)
+			if err != nil || got != "1512085950.000216" {
+				t.Errorf("postReport() = %v, %v", got, err)
+			}
+			if fmt.Sprint(posts) != fmt.Sprint(tt.want) {
+				t.Errorf("postReport() posts = \n%v, want \n%v", posts, tt.want)
+			}
+			if gotPrint := strings.TrimRight(buf.String(), "\n"); gotPrint != tt.err {
+				t.Errorf("postReport() = \n%v, want \n%v", gotPrint, tt.err)
+			}
+		})
+	}
+}
+
+func TestPostReply(t *testing.T) {
+	posts := []string{}
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+			text := r.FormValue("text")
+			if len(text) > 8 {
+				w.Write([]byte(`{"ok":false,"error":"msg_too_long"}`))
+				return
+			}
+			posts = append(posts, text)
+			w.Write([]byte(`{"ok":true,"channel":"C0123456789","ts":"1512085960.000216"}`))
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+	postReply(client, "C0123456789", "1512085950.000216", "aaa\nbbb\nccc\nddd\neee\n")
+	want := []string{"aaa\nbbb\n", "ccc\nddd\n", "eee\n"}
+	if fmt.Sprintf("%q", posts) != fmt.Sprintf("%q", want) {
+		t.Errorf("postReply() = %q, want %q", posts, want)
+	}
+}
+
+func TestWriteOutputs(t *testing.T) {
+	report := Report{
+		Period: ReportPeriod{Name: "day", From: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Title: []string{"2023-01-01", "Sunday"}},
+		Total:  3,
+		Channels: []ChannelReport{
+			{ID: "ABCDEF12345", Name: "channelName", Total: 3, Hosts: []Count{{Name: "example.com", Count: 2}, {Name: "a|b", Count: 1}}},
+		},
+		Threads: []ThreadReport{{ChannelID: "ABCDEF12345", ReplyCount: 2, Permalink: "https://example.slack.com/archives/ABCDEF12345/p1"}},
+	}
+	type want struct {
+		markdown string
+		json     bool
+		webhook  bool
+		log      string
+	}
+	tests := []struct {
+		name   string
+		status int
+		want   want
+	}{
+		{
+			name:   "ok",
+			status: http.StatusOK,
+			want: want{
+				markdown: "# 2023-01-01 Sunday\n\nTotal: 3\n\n## #channelName\n\nTotal: 3\n\n| Site | Count |\n| --- | --- |\n| example.com | 2 |\n| a\\|b | 1 |\n\n## Most discussed threads\n\n- [2 replies](https://example.slack.com/archives/ABCDEF12345/p1)\n",
+				json:     true,
+				webhook:  true,
+			},
+		},
+		{
+			name:   "webhookError",
+			status: http.StatusInternalServerError,
+			want: want{
+				markdown: "# 2023-01-01 Sunday\n\nTotal: 3\n\n## #channelName\n\nTotal: 3\n\n| Site | Count |\n| --- | --- |\n| example.com | 2 |\n| a\\|b | 1 |\n\n## Most discussed threads\n\n- [2 replies](https://example.slack.com/archives/ABCDEF12345/p1)\n",
+				json:     true,
+				webhook:  true,
+				log:      "can not post webhook: unexpected status: 500 Internal Server Error",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []byte
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("webhook Content-Type = %v", r.Header.Get("Content-Type"))
+				}
+				received, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer s.Close()
+			dir := t.TempDir()
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			log.SetFlags(0)
+			defer log.SetOutput(os.Stderr)
+			writeOutputs(report, filepath.Join(dir, "{date}.md"), filepath.Join(dir, "{date}.json"), s.URL)
+			if got := strings.TrimRight(buf.String(), "\n"); got != tt.want.log {
+				t.Errorf("writeOutputs() log = %v, want %v", got, tt.want.log)
+			}
+			markdown, err := os.ReadFile(filepath.Join(dir, "2023-01-01.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(markdown) != tt.want.markdown {
+				t.Errorf("writeOutputs() markdown = \n%v, want \n%v", string(markdown), tt.want.markdown)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, "2023-01-01.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var written Report
+			if err := json.Unmarshal(b, &written); err != nil || written.Total != report.Total {
+				t.Errorf("writeOutputs() json = %v, err = %v", string(b), err)
+			}
+			for _, key := range []string{`"period":`, `"channels":`, `"day_over_day":`, `"channel_id": "ABCDEF12345"`, `"reply_count": 2`} {
+				if !strings.Contains(string(b), key) {
+					t.Errorf("writeOutputs() json = %v, want %v", string(b), key)
+				}
+			}
+			var posted Report
+			if err := json.Unmarshal(received, &posted); err != nil || posted.Channels[0].Name != "channelName" {
+				t.Errorf("writeOutputs() webhook = %v, err = %v", string(received), err)
+			}
+		})
+	}
+}
+
+func TestWriteOutputsDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	writeOutputs(Report{}, "", "", "")
+	if buf.Len() != 0 {
+		t.Errorf("writeOutputs() log = %v, want empty", buf.String())
+	}
+	writeOutputs(Report{}, filepath.Join(t.TempDir(), "notExist", "report.md"), "", "")
+	if got := buf.String(); !strings.HasPrefix(got, "can not write markdown: open ") {
+		t.Errorf("writeOutputs() log = %v", got)
+	}
+}
+
+func TestResolveOwner(t *testing.T) {
+	type want struct {
+		owner *owner
+		err   string
+		log   string
+	}
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		authTest   string
+		usergroups string
+		want       want
+	}{
+		{
+			name:       "ok",
+			authTest:   "testdata/authTest/ok.json",
+			usergroups: "testdata/usergroupsList/ok.json",
+			want:       want{owner: &owner{userID: "U0123456789", groupIDs: []string{"S0123456789"}, questionAge: time.Hour, questionChannels: map[string]bool{}, now: now}},
+		},
+		{
+			name:       "usergroupsError",
+			authTest:   "testdata/authTest/ok.json",
+			usergroups: "testdata/usergroupsList/error.json",
+			want:       want{owner: &owner{userID: "U0123456789", groupIDs: []string{}, questionAge: time.Hour, questionChannels: map[string]bool{}, now: now}, log: "can not get user groups: missing_scope"},
+		},
+		{
+			name:       "authTestError",
+			authTest:   "testdata/authTest/error.json",
+			usergroups: "testdata/usergroupsList/ok.json",
+			want:       want{err: "invalid_auth"},
+		},
+	}
+	for _, tt := range tests {
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/auth.test", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile(tt.authTest)
+				w.Write(res)
+			})
+			c.Handle("/usergroups.list", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile(tt.usergroups)
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			log.SetFlags(0)
+			defer log.SetOutput(os.Stderr)
+			got, err := resolveOwner(client, time.Hour, map[string]bool{}, now)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.want.err {
+				t.Errorf("resolveOwner() err = %v, want %v", gotErr, tt.want.err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want.owner) {
+				t.Errorf("resolveOwner() = %v, want %v", got, tt.want.owner)
+			}
+			if log := strings.TrimRight(buf.String(), "\n"); log != tt.want.log {
+				t.Errorf("resolveOwner() log = %v, want %v", log, tt.want.log)
+			}
+		})
+	}
+}
+
+func TestIsMentioned(t *testing.T) {
+	o := &owner{userID: "U0123456789", groupIDs: []string{"S0123456789"}}
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{name: "user", text: "<@U0123456789> hi", want: true},
+		{name: "userWithName", text: "hi <@U0123456789|owner>", want: true},
+		{name: "group", text: "<!subteam^S0123456789|@team> hi", want: true},
+		{name: "groupWithoutHandle", text: "<!subteam^S0123456789> hi", want: true},
+		{name: "longerID", text: "<@U01234567890> hi", want: false},
+		{name: "longerIDBeforeUser", text: "<@U01234567890> and <@U0123456789>", want: true},
+		{name: "otherGroup", text: "<!subteam^S2345678901> hi", want: false},
+		{name: "noMention", text: "U0123456789", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := o.isMentioned(tt.text); got != tt.want {
+				t.Errorf("isMentioned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsUnanswered(t *testing.T) {
+	o := &owner{userID: "U0123456789", questionAge: time.Hour, now: tsToTime("1512089550.000216")}
+	message := func(text, ts string, replyCount int, reactions ...slack.ItemReaction) slack.Message {
+		return slack.Message{Msg: slack.Msg{Text: text, Timestamp: ts, ReplyCount: replyCount, Reactions: reactions}}
+	}
+	tests := []struct {
+		name    string
+		message slack.Message
+		replies []slack.Message
+		want    bool
+	}{
+		{name: "unanswered", message: message("can you check?", "1512085950.000216", 0), want: true},
+		{name: "fullWidth", message: message("わかりますか？ ", "1512085950.000216", 0), want: true},
+		{name: "notAQuestion", message: message("deployed", "1512085950.000216", 0), want: false},
+		{name: "tooYoung", message: message("can you check?", "1512085951.000216", 0), want: false},
+		{name: "reacted", message: message("can you check?", "1512085950.000216", 0, slack.ItemReaction{Name: "eyes", Count: 1}), want: false},
+		{name: "replied", message: message("can you check?", "1512085950.000216", 1), replies: []slack.Message{{Msg: slack.Msg{User: "U3456789012", Text: "yes", Timestamp: "1512085960.000216"}}}, want: false},
+		{name: "repliedLate", message: message("can you check?", "1512085950.000216", 1), replies: []slack.Message{{Msg: slack.Msg{User: "U3456789012", Text: "sorry", Timestamp: "1512089550.000216"}}}, want: true},
+		{name: "repliesUnknown", message: message("can you check?", "1512085950.000216", 1), want: false},
+		{
+			name:    "repliedByAsker",
+			message: slack.Message{Msg: slack.Msg{User: "U2345678901", Text: "anyone?", Timestamp: "1512085950.000216", ReplyCount: 1}},
+			replies: []slack.Message{{Msg: slack.Msg{User: "U2345678901", Text: "bump", Timestamp: "1512085960.000216"}}},
+			want:    true,
+		},
+		{
+			name:    "answeredAfterBump",
+			message: slack.Message{Msg: slack.Msg{User: "U2345678901", Text: "anyone?", Timestamp: "1512085950.000216", ReplyCount: 2}},
+			replies: []slack.Message{
+				{Msg: slack.Msg{User: "U2345678901", Text: "bump", Timestamp: "1512085960.000216"}},
+				{Msg: slack.Msg{User: "U3456789012", Text: "yes", Timestamp: "1512085970.000216"}},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := o.isUnanswered(tt.message, tt.replies); got != tt.want {
+				t.Errorf("isUnanswered() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "short", text: "can you check?", want: "can you check?"},
+		{name: "firstLine", text: " first\nsecond", want: "first"},
+		{name: "long", text: strings.Repeat("あ", 81), want: strings.Repeat("あ", 80) + "…"},
+		{name: "cutMention", text: strings.Repeat("a", 75) + " <@U0123456789>", want: strings.Repeat("a", 75) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(tt.text); got != tt.want {
+				t.Errorf("snippet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeResultPersonal(t *testing.T) {
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/conversations.history", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/conversationsHistory/personalMessages.json")
+			w.Write(res)
+		})
+		c.Handle("/conversations.replies", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/conversationsReplies/answeredQuestion.json")
+			w.Write(res)
+		})
+		c.Handle("/chat.getPermalink", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/chatGetPermalink/ok.json")
+			w.Write(res)
+		})
+		c.Handle("/users.list", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/usersList/ok.json")
+			w.Write(res)
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+	users, _ := loadUserCache(client, "", DEFAULT_USER_CACHE_TTL)
+	day, _ := makePeriod("day", "", "", time.Now())
+	o := &owner{userID: "U0123456789", groupIDs: []string{"S0123456789"}, questionAge: time.Hour, now: time.Now()}
+	c := &config{userClient: client, period: day, users: users, owner: o}
+	r := c.makeResult([]slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}})
+
+	permalink := "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"
+	wantMentions := []personalItem{
+		{channelID: "ABCDEF12345", ts: "1512085950.000216", text: "<@U0123456789> can you check?", permalink: permalink},
+		{channelID: "ABCDEF12345", ts: "1512085951.000216", text: "<!subteam^S0123456789|@team> deployed", permalink: permalink},
+	}
+	if fmt.Sprint(r.mentions) != fmt.Sprint(wantMentions) {
+		t.Errorf("makeResult() mentions = %v, want %v", r.mentions, wantMentions)
+	}
+	wantQuestions := []personalItem{{channelID: "ABCDEF12345", ts: "1512085950.000216", text: "<@U0123456789> can you check?", permalink: permalink}}
+	if fmt.Sprint(r.questions) != fmt.Sprint(wantQuestions) {
+		t.Errorf("makeResult() questions = %v, want %v", r.questions, wantQuestions)
+	}
+
+	c.owner = nil
+	r = c.makeResult([]slack.Channel{{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}})
+	if len(r.mentions) != 0 || len(r.questions) != 0 {
+		t.Errorf("makeResult() without owner mentions = %v, questions = %v", r.mentions, r.questions)
+	}
+}
+
+func TestFindQuestions(t *testing.T) {
+	type want struct {
+		questions []personalItem
+		channels  []string
+		log       string
+	}
+	tests := []struct {
+		name             string
+		questionChannels map[string]bool
+		replies          string
+		want             want
+	}{
+		{
+			name:             "allChannels",
+			questionChannels: map[string]bool{},
+			replies:          "testdata/conversationsReplies/answeredQuestion.json",
+			want: want{
+				questions: []personalItem{
+					{channelID: "ABCDEF12345", ts: "1512085950.000216", text: "<@U0123456789> can you check?"},
+					{channelID: "ABCDEF01234", ts: "1512085950.000216", text: "<@U0123456789> can you check?"},
+				},
+				channels: []string{"ABCDEF12345", "ABCDEF01234"},
+			},
+		},
+		{
+			name:             "monitoredChannels",
+			questionChannels: map[string]bool{"ABCDEF01234": true},
+			replies:          "testdata/conversationsReplies/answeredQuestion.json",
+			want: want{
+				questions: []personalItem{{channelID: "ABCDEF01234", ts: "1512085950.000216", text: "<@U0123456789> can you check?"}},
+				channels:  []string{"ABCDEF01234"},
+			},
+		},
+		{
+			name:             "repliesError",
+			questionChannels: map[string]bool{"ABCDEF01234": true},
+			replies:          "testdata/conversationsReplies/error.json",
+			want: want{
+				questions: []personalItem{{channelID: "ABCDEF01234", ts: "1512085950.000216", text: "<@U0123456789> can you check?"}},
+				channels:  []string{"ABCDEF01234"},
+				log:       "can not get replies channelID: ABCDEF01234 ts: 1512085954.000216 channel_not_found",
+			},
+		},
+	}
+	now := time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)
+	day, _ := makePeriod("day", "", "", now)
+	for _, tt := range tests {
+		channels := []string{}
+		params := []string{}
+		ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+			c.Handle("/conversations.history", func(w http.ResponseWriter, r *http.Request) {
+				channels = append(channels, r.FormValue("channel"))
+				params = append(params, r.FormValue("oldest")+"-"+r.FormValue("latest"))
+				res, _ := testdata.ReadFile("testdata/conversationsHistory/personalMessages.json")
+				w.Write(res)
+			})
+			c.Handle("/conversations.replies", func(w http.ResponseWriter, _ *http.Request) {
+				res, _ := testdata.ReadFile(tt.replies)
+				w.Write(res)
+			})
+		})
+		ts.Start()
+		client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+		o := &owner{userID: "U0123456789", questionAge: 2 * time.Hour, questionChannels: tt.questionChannels, now: now}
+		c := &config{userClient: client, period: day, owner: o}
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			log.SetFlags(0)
+			defer log.SetOutput(os.Stderr)
+			got := c.findQuestions([]slack.Channel{
+				{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "ABCDEF12345"}}},
+				{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "ABCDEF01234"}}},
+			})
+			if fmt.Sprint(got) != fmt.Sprint(tt.want.questions) {
+				t.Errorf("findQuestions() = %v, want %v", got, tt.want.questions)
+			}
+			if fmt.Sprint(channels) != fmt.Sprint(tt.want.channels) {
+				t.Errorf("findQuestions() channels = %v, want %v", channels, tt.want.channels)
+			}
+			latest := now.Add(-2 * time.Hour)
+			wantParam := strconv.FormatInt(latest.Add(-day.to.Sub(day.from)).Unix(), 10) + "-" + strconv.FormatInt(latest.Unix(), 10)
+			for _, param := range params {
+				if param != wantParam {
+					t.Errorf("findQuestions() oldest-latest = %v, want %v", param, wantParam)
+				}
+			}
+			if log := strings.TrimRight(buf.String(), "\n"); log != tt.want.log {
+				t.Errorf("findQuestions() log = %v, want %v", log, tt.want.log)
+			}
+		})
+	}
+}
+
+func TestCreatePersonalMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		r    result
+		want string
+	}{
+		{name: "empty", r: result{}, want: ""},
+		{
+			name: "mentionsAndQuestions",
+			r: result{
+				mentions:  []personalItem{{channelID: "ABCDEF12345", text: "<@U0123456789> can you check?", permalink: "https://example.slack.com/p1"}},
+				questions: []personalItem{{channelID: "ABCDEF01234", text: "who knows?\ndetails"}},
+			},
+			want: "*Mentions*\n• <#ABCDEF12345> <@U0123456789> can you check? <https://example.slack.com/p1|open>\n*Unanswered questions*\n• <#ABCDEF01234> who knows?\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createPersonalMessage(tt.r); got != tt.want {
+				t.Errorf("createPersonalMessage() = \n%v, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostPersonalMessage(t *testing.T) {
+	posts := []string{}
+	opened := []string{}
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/conversations.open", func(w http.ResponseWriter, r *http.Request) {
+			opened = append(opened, r.FormValue("users"))
+			res, _ := testdata.ReadFile("testdata/conversationsOpen/ok.json")
+			w.Write(res)
+		})
+		c.Handle("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+			posts = append(posts, r.FormValue("channel")+" "+r.FormValue("text"))
+			w.Write([]byte(`{"ok":true,"channel":"D0123456789","ts":"1512085960.000216"}`))
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+	if err := postPersonalMessage(client, "U0123456789", "*Mentions*\n"); err != nil {
+		t.Errorf("postPersonalMessage() err = %v", err)
+	}
+	if fmt.Sprint(opened) != "[U0123456789]" {
+		t.Errorf("postPersonalMessage() opened = %v", opened)
+	}
+	if want := []string{"D0123456789 *Mentions*\n"}; fmt.Sprint(posts) != fmt.Sprint(want) {
+		t.Errorf("postPersonalMessage() = %v, want %v", posts, want)
+	}
+}
+
+func TestThreadResponse(t *testing.T) {
+	c := &config{resolvedEmoji: "white_check_mark"}
+	resolved := slack.ItemReaction{Name: "white_check_mark", Count: 1}
+	message := func(user, ts string, reactions ...slack.ItemReaction) slack.Message {
+		return slack.Message{Msg: slack.Msg{User: user, Timestamp: ts, Reactions: reactions}}
+	}
+	type want struct {
+		firstReply time.Duration
+		resolved   time.Duration
+	}
+	tests := []struct {
+		name    string
+		message slack.Message
+		replies []slack.Message
+		want    want
+	}{
+		{name: "noReply", message: message("U1", "1512085950.000216"), want: want{firstReply: -1, resolved: -1}},
+		{name: "onlyAuthor", message: message("U1", "1512085950.000216"), replies: []slack.Message{message("U1", "1512085960.000216")}, want: want{firstReply: -1, resolved: -1}},
+		{name: "replied", message: message("U1", "1512085950.000216"), replies: []slack.Message{message("U1", "1512085960.000216"), message("U2", "1512086010.000216")}, want: want{firstReply: time.Minute, resolved: -1}},
+		{name: "resolvedReply", message: message("U1", "1512085950.000216"), replies: []slack.Message{message("U2", "1512086010.000216"), message("U3", "1512089550.000216", resolved)}, want: want{firstReply: time.Minute, resolved: time.Hour}},
+		{name: "resolvedMessage", message: message("U1", "1512085950.000216", resolved), replies: []slack.Message{message("U2", "1512086010.000216"), message("U1", "1512086070.000216")}, want: want{firstReply: time.Minute, resolved: 2 * time.Minute}},
+		{name: "resolvedWithoutReply", message: message("U1", "1512085950.000216", resolved), want: want{firstReply: -1, resolved: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			firstReply, resolved := c.threadResponse(tt.message, tt.replies)
+			if firstReply != tt.want.firstReply || resolved != tt.want.resolved {
+				t.Errorf("threadResponse() = %v, %v, want %v, %v", firstReply, resolved, tt.want.firstReply, tt.want.resolved)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	durations := []time.Duration{5 * time.Minute, time.Minute, 3 * time.Minute, 2 * time.Minute, 4 * time.Minute}
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{name: "median", durations: durations, p: 0.5, want: 3 * time.Minute},
+		{name: "p90", durations: durations, p: 0.9, want: 5 * time.Minute},
+		{name: "one", durations: []time.Duration{time.Minute}, p: 0.9, want: time.Minute},
+		{name: "none", durations: nil, p: 0.5, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.durations, tt.p); got != tt.want {
+				t.Errorf("percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if fmt.Sprint(durations) != "[5m0s 1m0s 3m0s 2m0s 4m0s]" {
+		t.Errorf("percentile() sorted its argument %v", durations)
+	}
+}
+
+func TestResponseLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *ResponseReport
+		want     string
+	}{
+		{name: "nil", response: nil, want: ""},
+		{name: "noThread", response: &ResponseReport{}, want: ""},
+		{name: "allUnanswered", response: &ResponseReport{Threads: 2, Unanswered: 2}, want: "unanswered 2/2"},
+		{
+			name:     "resolved",
+			response: &ResponseReport{Threads: 3, Unanswered: 1, FirstReplyMedian: 45 * time.Second, FirstReplyP90: 12 * time.Minute, Resolved: 2, ResolvedMedian: 3*time.Hour + 5*time.Minute, ResolvedP90: 26 * time.Hour},
+			want:     "first reply 45s / p90 12m, resolved 3h5m / p90 1d2h, unanswered 1/3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := responseLine(tt.response); got != tt.want {
+				t.Errorf("responseLine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeResultResponses(t *testing.T) {
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/conversations.history", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/conversationsHistory/supportMessages.json")
+			w.Write(res)
+		})
+		c.Handle("/chat.getPermalink", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/chatGetPermalink/ok.json")
+			w.Write(res)
+		})
+		c.Handle("/conversations.replies", func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("latest") != "" {
+				t.Errorf("conversations.replies latest = %v, want empty", r.FormValue("latest"))
+			}
+			path := "testdata/conversationsReplies/supportThread.json"
+			if r.FormValue("ts") == "1512086100.000216" {
+				path = "testdata/conversationsReplies/resolvedThread.json"
+			}
+			res, _ := testdata.ReadFile(path)
+			w.Write(res)
+		})
+		c.Handle("/users.list", func(w http.ResponseWriter, _ *http.Request) {
+			res, _ := testdata.ReadFile("testdata/usersList/ok.json")
+			w.Write(res)
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+	users, _ := loadUserCache(client, "", DEFAULT_USER_CACHE_TTL)
+	day, _ := makePeriod("day", "", "", time.Now())
+	c := &config{userClient: client, period: day, users: users, responseChannels: map[string]bool{"ABCDEF12345": true}, resolvedEmoji: "white_check_mark"}
+	conversations := []slack.Channel{
+		{GroupConversation: slack.GroupConversation{Name: "support", Conversation: slack.Conversation{ID: "ABCDEF12345"}}},
+		{GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF01234"}}},
+	}
+	r := c.makeResult(conversations)
+	want := map[string]*responses{"ABCDEF12345": {threads: 3, unanswered: 1, firstReply: []time.Duration{10 * time.Minute, time.Hour}, resolved: []time.Duration{10 * time.Minute, time.Hour}}}
+	if fmt.Sprint(len(r.responsesByChannel), *r.responsesByChannel["ABCDEF12345"]) != fmt.Sprint(len(want), *want["ABCDEF12345"]) {
+		t.Errorf("makeResult() responsesByChannel = %v, want %v", *r.responsesByChannel["ABCDEF12345"], *want["ABCDEF12345"])
+	}
+	if len(r.threads) != 0 {
+		t.Errorf("makeResult() threads = %v, want none without SUMMARY_REPLIES", r.threads)
+	}
+
+	channelById := map[string]slack.Channel{}
+	for _, channel := range conversations {
+		channelById[channel.ID] = channel
+	}
+	report := c.makeReport(r, channelById)
+	found := false
+	for _, channel := range report.Channels {
+		if channel.ID == "ABCDEF01234" && channel.Response != nil {
+			t.Errorf("makeReport() Response = %v, want nil", channel.Response)
+		}
+		if channel.ID == "ABCDEF12345" {
+			found = true
+			if got, want := responseLine(channel.Response), "first reply 10m / p90 1h0m, resolved 10m / p90 1h0m, unanswered 1/3"; got != want {
+				t.Errorf("makeReport() Response = %v, want %v", got, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("makeReport() Channels = %v, want ABCDEF12345", report.Channels)
+	}
+}
+
+func TestWindowQuery(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	day, _ := makePeriod("day", "", "", time.Date(2023, 1, 2, 9, 0, 0, 0, tokyo))
+	week, _ := makePeriod("week", "", "", time.Date(2023, 1, 9, 9, 0, 0, 0, tokyo))
+	tests := []struct {
+		name   string
+		period period
+		want   string
+	}{
+		{name: "day", period: day, want: "outage after:2022-12-30 before:2023-01-03"},
+		{name: "week", period: week, want: "outage after:2022-12-31 before:2023-01-10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config{period: tt.period}
+			if got := c.windowQuery("outage"); got != tt.want {
+				t.Errorf("windowQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchQueries(t *testing.T) {
+	queries := []string{}
+	ts := slacktest.NewTestServer(func(c slacktest.Customize) {
+		c.Handle("/search.messages", func(w http.ResponseWriter, r *http.Request) {
+			queries = append(queries, r.FormValue("query")+" count:"+r.FormValue("count")+" page:"+r.FormValue("page"))
+			path := "testdata/searchMessages/ok.json"
+			if r.FormValue("page") == "2" {
+				path = "testdata/searchMessages/page2.json"
+			}
+			if strings.HasPrefix(r.FormValue("query"), "broken") {
+				path = "testdata/searchMessages/error.json"
+			}
+			res, _ := testdata.ReadFile(path)
+			w.Write(res)
+		})
+	})
+	ts.Start()
+	client := slack.New("testToken", slack.OptionAPIURL(ts.GetAPIURL()))
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+
+	// the DM, the match before the period and the one after it are not counted
+	day, _ := makePeriod("day", "", "", time.Date(2017, 12, 1, 9, 0, 0, 0, time.UTC))
+	c := &config{userClient: client, period: day, queryByName: map[string]string{"outages": "outage", "broken": "broken query"}}
+	got := c.searchQueries([]slack.Channel{
+		{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "ABCDEF12345"}}},
+		{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "ABCDEF01234"}}},
+	})
+	want := []tracker{{name: "outages", query: "outage", hits: 3, matches: []personalItem{
+		{channelID: "ABCDEF12345", ts: "1512085950.000216", text: "outage on the api", permalink: "https://example.slack.com/archives/ABCDEF12345/p1512085950000216"},
+		{channelID: "ABCDEF01234", ts: "1512085960.000216", text: "the outage is over", permalink: "https://example.slack.com/archives/ABCDEF01234/p1512085960000216"},
+		{channelID: "ABCDEF12345", ts: "1512043200.000000", text: "outage again", permalink: "https://example.slack.com/archives/ABCDEF12345/p1512043200000000"},
+	}}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("searchQueries() = %v, want %v", got, want)
+	}
+	wantQueries := []string{"broken query after:2017-11-28 before:2017-12-02 count:100 page:", "outage after:2017-11-28 before:2017-12-02 count:100 page:", "outage after:2017-11-28 before:2017-12-02 count:100 page:2"}
+	if fmt.Sprint(queries) != fmt.Sprint(wantQueries) {
+		t.Errorf("searchQueries() queries = %v, want %v", queries, wantQueries)
+	}
+	if log := strings.TrimRight(buf.String(), "\n"); log != "can not search query: broken not_allowed_token_type" {
+		t.Errorf("searchQueries() log = %v", log)
+	}
+
+	report := c.makeReport(result{trackers: got}, map[string]slack.Channel{})
+	text, err := renderTemplate(DEFAULT_TEMPLATE, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantText := "\nKeyword trackers\noutages : 3 hits\n<#ABCDEF12345> outage on the api https://example.slack.com/archives/ABCDEF12345/p1512085950000216\n<#ABCDEF01234> the outage is over https://example.slack.com/archives/ABCDEF01234/p1512085960000216\n<#ABCDEF12345> outage again https://example.slack.com/archives/ABCDEF12345/p1512043200000000\n"
+	if !strings.HasSuffix(text, wantText) {
+		t.Errorf("renderTemplate() = \n%v, want suffix \n%v", text, wantText)
+	}
+	blocks := extraBlocks(report)
+	if len(blocks) != 2 {
+		t.Fatalf("extraBlocks() = %v, want a divider and a section", blocks)
+	}
+	wantSection := "*Keyword trackers*\n*outages* : 3 hits\n• <#ABCDEF12345> outage on the api <https://example.slack.com/archives/ABCDEF12345/p1512085950000216|open>\n• <#ABCDEF01234> the outage is over <https://example.slack.com/archives/ABCDEF01234/p1512085960000216|open>\n• <#ABCDEF12345> outage again <https://example.slack.com/archives/ABCDEF12345/p1512043200000000|open>\n"
+	if section, ok := blocks[1].(*slack.SectionBlock); !ok || section.Text.Text != wantSection {
+		t.Errorf("extraBlocks() = %v, want %v", blocks[1], wantSection)
+	}
+}
+
+func TestHumanNames(t *testing.T) {
+	users := &userCache{ttl: time.Hour, listed: true, users: map[string]CachedUser{
+		"U0123456789": {Name: "alex", FetchedAt: time.Now()},
+		"U1234567890": {Name: "alex", FetchedAt: time.Now()},
+	}}
+	channelById := map[string]slack.Channel{"ABCDEF12345": {GroupConversation: slack.GroupConversation{Name: "channelName", Conversation: slack.Conversation{ID: "ABCDEF12345"}}}}
+	r := result{
+		countByChannel:        map[string]int{"ABCDEF12345": 4},
+		countByHumanByChannel: map[string]map[string]int{"ABCDEF12345": {"U0123456789": 3, "U1234567890": 1}},
+	}
+	tests := []struct {
+		name      string
+		anonymize bool
+		want      string
+	}{
+		{name: "sameName", want: "alex 3 / alex 1"},
+		{name: "anonymized", anonymize: true, want: "user 1 3 / user 2 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, _ := makePeriod("day", "", "", time.Now())
+			c := &config{period: day, users: users, anonymize: tt.anonymize}
+			report := c.makeReport(r, channelById)
+			names := func(counts []Count) string {
+				humans := []string{}
+				for _, count := range counts {
+					humans = append(humans, count.Name+" "+strconv.Itoa(count.Count))
+				}
+				return strings.Join(humans, " / ")
+			}
+			if got := names(report.Humans); got != tt.want {
+				t.Errorf("makeReport() Humans = %v, want %v", got, tt.want)
+			}
+			if got := names(report.Channels[0].Humans); got != tt.want {
+				t.Errorf("makeReport() Channels[0].Humans = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChartLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		label func() string
+		want  string
+	}{
+		{name: "asciiChannel", label: func() string { return chartLabel("#general", "C0123456789") }, want: "#general"},
+		{name: "japaneseChannel", label: func() string { return chartLabel("#雑談", "C0123456789") }, want: "C0123456789"},
+		{name: "asciiSite", label: func() string { return siteLabel("example.com") }, want: "example.com"},
+		{name: "japaneseSite", label: func() string { return siteLabel("日本.jp") }, want: "xn--wgv71a.jp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.label(); got != tt.want {
+				t.Errorf("label = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type recordingExporter struct {
+	sdkmetric.Exporter
+	exported *metricdata.ResourceMetrics
+}
+
+func (e *recordingExporter) Export(_ context.Context, rm *metricdata.ResourceMetrics) error {
+	e.exported = rm
+	return nil
+}
+
+func TestPeriodExporter(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	p, _ := makePeriod("day", "", "", time.Date(2023, 1, 2, 9, 0, 0, 0, tokyo))
+	exportedAt := time.Date(2023, 1, 2, 0, 5, 0, 0, time.UTC)
+	rm := &metricdata.ResourceMetrics{ScopeMetrics: []metricdata.ScopeMetrics{{Metrics: []metricdata.Metrics{
+		{Name: "counter", Data: metricdata.Sum[int64]{DataPoints: []metricdata.DataPoint[int64]{{StartTime: exportedAt, Time: exportedAt, Value: 1}}}},
+		{Name: "gauge", Data: metricdata.Gauge[int64]{DataPoints: []metricdata.DataPoint[int64]{{Time: exportedAt, Value: 1}}}},
+		{Name: "histogram", Data: metricdata.Histogram[float64]{DataPoints: []metricdata.HistogramDataPoint[float64]{{StartTime: exportedAt, Time: exportedAt, Count: 1}}}},
+	}}}}
+	recorder := &recordingExporter{}
+	if err := (periodExporter{Exporter: recorder, period: p}).Export(context.Background(), rm); err != nil {
+		t.Fatal(err)
+	}
+	// the day before the run in Tokyo starts at 15:00 UTC
+	wantFrom, wantTo := time.Date(2022, 12, 31, 15, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 15, 0, 0, 0, time.UTC)
+	for _, m := range recorder.exported.ScopeMetrics[0].Metrics {
+		var from, to time.Time
+		switch data := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			from, to = data.DataPoints[0].StartTime, data.DataPoints[0].Time
+		case metricdata.Gauge[int64]:
+			from, to = data.DataPoints[0].StartTime, data.DataPoints[0].Time
+		case metricdata.Histogram[float64]:
+			from, to = data.DataPoints[0].StartTime, data.DataPoints[0].Time
+		}
+		if !from.Equal(wantFrom) || !to.Equal(wantTo) {
+			t.Errorf("Export() %v = %v - %v, want %v - %v", m.Name, from, to, wantFrom, wantTo)
+		}
+	}
+}